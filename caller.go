@@ -2,13 +2,18 @@ package errors
 
 import (
 	"fmt"
+	"path"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // Caller defines an interface to runtime caller results.
 type Caller interface {
 	File() string
+	Function() string
 	Line() int
 	Ok() bool
 	Pc() uintptr
@@ -18,6 +23,16 @@ type Caller interface {
 // Trace defines an error trace.
 type Trace []Caller
 
+// String implements Stringer, returning each caller on its own line in the
+// form "file:line function".
+func (trace Trace) String() string {
+	lines := make([]string, 0, len(trace))
+	for _, caller := range trace {
+		lines = append(lines, callerLine(caller)+" "+callerFunc(caller))
+	}
+	return strings.Join(lines, "\n")
+}
+
 // Call implements lkcloud/std/error.Caller, holding runtime.Caller data.
 type Call struct {
 	loaded bool
@@ -25,6 +40,9 @@ type Call struct {
 	line   int
 	ok     bool
 	pc     uintptr
+	// Function name for callers without a program counter, set by
+	// WithCaller.
+	function string
 }
 
 // File implements lkcloud/std/error.Caller, returning the caller file name.
@@ -32,11 +50,67 @@ func (call Call) File() string {
 	return call.file
 }
 
+// funcNames caches function names by program counter, so formatting the
+// same error repeatedly doesn't resolve them again. It's bounded by the
+// number of call sites that create or wrap errors.
+var funcNames sync.Map
+
+// Function implements lkcloud/std/error.Caller, returning the fully
+// qualified name of the caller function, or an empty string if it isn't
+// known.
+func (call Call) Function() string {
+	if "" != call.function {
+		return call.function
+	}
+	if 0 == call.pc {
+		return ""
+	}
+	if name, ok := funcNames.Load(call.pc); ok {
+		return name.(string)
+	}
+	name := ""
+	if fn := runtime.FuncForPC(call.pc); nil != fn {
+		name = fn.Name()
+	}
+	funcNames.Store(call.pc, name)
+	return name
+}
+
 // Line implements lkcloud/std/error.Caller, returning the caller line number.
 func (call Call) Line() int {
 	return call.line
 }
 
+// MarshalText implements encoding.TextMarshaler, encoding the caller as
+// "file:line:function" with the full file path and function name.
+func (call Call) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%s:%d:%s", call.file, call.line, call.Function())), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, decoding a caller
+// encoded by MarshalText. The function may be omitted, as in "file:line".
+// Program counters can't be recovered so decoded callers report
+// Ok() == false.
+func (call *Call) UnmarshalText(text []byte) error {
+	str, fn := string(text), ""
+	k := strings.LastIndex(str, ":")
+	if k >= 0 {
+		if _, e := strconv.Atoi(str[k+1:]); nil != e {
+			str, fn = str[:k], str[k+1:]
+			k = strings.LastIndex(str, ":")
+		}
+	}
+	if k < 0 {
+		return New(ErrDecodingFailed, "caller %q is not in the form file:line:function", text)
+	}
+	line, e := strconv.Atoi(str[k+1:])
+	if nil != e {
+		return New(ErrDecodingFailed, "caller %q is not in the form file:line:function", text)
+	}
+	*call = Call{file: str[:k], line: line, function: fn}
+	return nil
+}
+
 // Ok implements lkcloud/std/error.Caller, returning whether the caller data was successfully recovered.
 func (call Call) Ok() bool {
 	return call.ok
@@ -53,17 +127,164 @@ func (call Call) String() string {
 		"%s:%d %s",
 		call.file,
 		call.line,
-		runtime.FuncForPC(call.pc).Name(),
+		call.Function(),
 	)
 }
 
+// noCaller is displayed in place of missing caller information.
+const noCaller = "<no caller>"
+
+// shortFuncNames is non-zero when function names in output omit the
+// package path.
+var shortFuncNames int32
+
+// SetShortFuncNames enables or disables omitting the package path from
+// function names in formatted output, so that
+// "github.com/lkcloud/errors_test.loadConfig" is shown as "loadConfig".
+func SetShortFuncNames(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&shortFuncNames, v)
+}
+
+// funcName returns the function name of a caller for output.
+func funcName(caller Caller) string {
+	name := caller.Function()
+	if 0 != atomic.LoadInt32(&shortFuncNames) && "" != name {
+		name = strings.TrimPrefix(name[len(funcPackage(name)):], ".")
+	}
+	return name
+}
+
+// callerFunc returns the function name of a caller, if known.
+func callerFunc(caller Caller) string {
+	if nil == caller {
+		return noCaller
+	}
+	if name := funcName(caller); "" != name {
+		return name
+	}
+	return noCaller
+}
+
+// trimPrefix is removed from caller file paths in output, if set.
+var trimPrefix string
+var trimPrefixMux = &sync.RWMutex{}
+
+// SetTrimPrefix sets a path prefix, such as the module root, that is
+// removed from caller file paths in formatted output, so that files are
+// shown relative to it like "internal/config/handler.go". Files outside
+// the prefix, or all files if the prefix is empty, are shown by their base
+// name.
+func SetTrimPrefix(prefix string) {
+	trimPrefixMux.Lock()
+	trimPrefix = strings.TrimSuffix(prefix, "/")
+	trimPrefixMux.Unlock()
+}
+
+// callerFile returns the file path of a caller for output, relative to the
+// trim prefix if possible.
+func callerFile(caller Caller) string {
+	trimPrefixMux.RLock()
+	prefix := trimPrefix
+	trimPrefixMux.RUnlock()
+	if "" != prefix && strings.HasPrefix(caller.File(), prefix+"/") {
+		return strings.TrimPrefix(caller.File(), prefix+"/")
+	}
+	return path.Base(caller.File())
+}
+
+// callerLine returns the "file:line" form of a caller, if known.
+func callerLine(caller Caller) string {
+	if nil == caller || "" == caller.File() {
+		return noCaller
+	}
+	return fmt.Sprintf("%s:%d", callerFile(caller), caller.Line())
+}
+
+// callerText returns the condensed "file:line:func" form of a caller. The
+// function name is omitted if it isn't known.
+func callerText(caller Caller) string {
+	if nil == caller || "" == caller.File() {
+		return noCaller
+	}
+	return fmt.Sprintf("%s:%d:%s", callerFile(caller), caller.Line(), funcName(caller))
+}
+
+// pkgName is the import path of this package as seen by the runtime,
+// used to skip internal frames regardless of the module path, vendoring
+// or where the package is checked out.
+var pkgName = func() string {
+	pc, _, _, _ := runtime.Caller(0)
+	return funcPackage(runtime.FuncForPC(pc).Name())
+}()
+
+// funcPackage returns the package import path of a fully qualified
+// function name such as "github.com/lkcloud/errors.(*Err).Wrap".
+func funcPackage(name string) string {
+	slash := strings.LastIndex(name, "/")
+	if dot := strings.Index(name[slash+1:], "."); dot >= 0 {
+		return name[:slash+1+dot]
+	}
+	return name
+}
+
+// isPackageFrame returns whether a frame in file calling the function
+// named fn belongs to the package pkg. Test files are never considered
+// part of the package so that tests report their own callers.
+func isPackageFrame(pkg, file, fn string) bool {
+	return pkg == funcPackage(fn) &&
+		!strings.HasSuffix(strings.ToLower(file), "_test.go")
+}
+
+// callerSkipFunc reports additional frames to skip when capturing
+// callers, if set.
+var callerSkipFunc func(file string, funcName string) bool
+var callerSkipMux = &sync.RWMutex{}
+
+// SetCallerSkipFunc sets a function reporting whether a frame should be
+// skipped when capturing the caller of an error, in addition to frames in
+// this package. This allows frameworks and helper packages to have errors
+// report the caller of their wrapper functions instead. file is the full
+// path of the source file and funcName the fully qualified function name.
+// Passing nil removes the function.
+func SetCallerSkipFunc(fn func(file string, funcName string) bool) {
+	callerSkipMux.Lock()
+	callerSkipFunc = fn
+	callerSkipMux.Unlock()
+}
+
+// isInternalFrame returns whether caller belongs to this package or should
+// be skipped according to SetCallerSkipFunc.
+func isInternalFrame(caller Caller) bool {
+	fn := caller.Function()
+	if "" == fn {
+		return false
+	}
+	if isPackageFrame(pkgName, caller.File(), fn) {
+		return true
+	}
+	callerSkipMux.RLock()
+	skip := callerSkipFunc
+	callerSkipMux.RUnlock()
+	return nil != skip && skip(caller.File(), fn)
+}
+
+// getCaller returns the first caller outside this package.
 func getCaller() Caller {
+	return getCallerSkip(0)
+}
+
+// getCallerSkip returns the first caller outside this package, skipping an
+// additional skip frames beyond it.
+func getCallerSkip(skip int) Caller {
 	var caller Call
 	a := 0
 	for {
 		if caller.pc, caller.file, caller.line, caller.ok = runtime.Caller(a); caller.ok {
-			if !strings.Contains(strings.ToLower(caller.file), "github.com/lkcloud/errors") ||
-				strings.HasSuffix(strings.ToLower(caller.file), "_test.go") {
+			if !isInternalFrame(caller) {
 				break
 			}
 		} else {
@@ -71,14 +292,130 @@ func getCaller() Caller {
 		}
 		a++
 	}
+	if skip > 0 && caller.ok {
+		caller = Call{}
+		caller.pc, caller.file, caller.line, caller.ok = runtime.Caller(a + skip)
+	}
 	return caller
 }
 
+// panicCaller returns the caller that panicked from a trace captured while
+// recovering, skipping the runtime's panic handling frames. If the panic
+// can't be located, the nearest caller outside this package is returned.
+func panicCaller(trace Trace) Caller {
+	panicking := false
+	for _, caller := range trace {
+		fn := caller.Function()
+		if "" == fn {
+			continue
+		}
+		if "runtime.gopanic" == fn {
+			panicking = true
+		} else if panicking && !strings.HasPrefix(fn, "runtime.") {
+			return caller
+		}
+	}
+	return getCaller()
+}
+
+// traceEnabled is non-zero when call stacks are captured for new errors.
+var traceEnabled int32 = 1
+
+// SetTraceEnabled enables or disables capturing the call stack of new
+// errors. Callers are always captured.
+func SetTraceEnabled(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&traceEnabled, v)
+}
+
+// TraceEnabled returns whether the call stack of new errors is captured.
+func TraceEnabled() bool {
+	return 1 == atomic.LoadInt32(&traceEnabled)
+}
+
+// traceSampler reports whether the call stack of a new error should be
+// captured, if set.
+var traceSampler func() bool
+var traceSamplerMux = &sync.RWMutex{}
+
+// SetTraceSampler sets a function reporting whether the call stack of a
+// new error should be captured, so that busy error paths can capture a
+// sample of traces, such as 1% of them, instead of every one. Errors that
+// aren't sampled still capture their caller, code and message, and their
+// formatted traces show one caller per frame. The sampler is only called
+// while tracing is enabled, and doesn't apply to panics or WithStack.
+// Passing nil removes the sampler.
+func SetTraceSampler(fn func() bool) {
+	traceSamplerMux.Lock()
+	traceSampler = fn
+	traceSamplerMux.Unlock()
+}
+
+// traceSampled reports whether the call stack of a new error should be
+// captured according to SetTraceSampler.
+func traceSampled() bool {
+	traceSamplerMux.RLock()
+	sample := traceSampler
+	traceSamplerMux.RUnlock()
+	return nil == sample || sample()
+}
+
+// lazyTrace is non-zero when resolving call stacks is deferred until the
+// trace is read.
+var lazyTrace int32 = 1
+
+// SetLazyTrace enables or disables deferring the resolution of the call
+// stack of a new error into file and line information until the trace is
+// read, which is enabled by default. If disabled, the call stack is
+// resolved as the error is created.
+func SetLazyTrace(lazy bool) {
+	var v int32
+	if lazy {
+		v = 1
+	}
+	atomic.StoreInt32(&lazyTrace, v)
+}
+
+// LazyTrace returns whether the resolution of call stacks is deferred
+// until the trace is read.
+func LazyTrace() bool {
+	return 1 == atomic.LoadInt32(&lazyTrace)
+}
+
+// maxTraceDepth is the maximum number of frames captured in the call stack
+// of a new error.
+var maxTraceDepth int32 = 32
+
+// maxTraceDepthLimit is the largest depth accepted by SetMaxTraceDepth.
+const maxTraceDepthLimit = 1024
+
+// SetMaxTraceDepth sets the maximum number of frames captured in the call
+// stack of a new error, 32 by default. A depth of 0 or less disables call
+// stack capture and depths above 1024 are treated as 1024. Callers are
+// always captured.
+func SetMaxTraceDepth(depth int) {
+	if depth < 0 {
+		depth = 0
+	} else if depth > maxTraceDepthLimit {
+		depth = maxTraceDepthLimit
+	}
+	atomic.StoreInt32(&maxTraceDepth, int32(depth))
+}
+
+// MaxTraceDepth returns the maximum number of frames captured in the call
+// stack of a new error.
+func MaxTraceDepth() int {
+	return int(atomic.LoadInt32(&maxTraceDepth))
+}
+
 func getTrace() Trace {
 	var trace Trace
 	var caller Call
 	a := 0
-	for {
+	for max := MaxTraceDepth(); len(trace) < max; {
 		if caller.pc, caller.file, caller.line, caller.ok = runtime.Caller(a); caller.ok {
 			trace = append(trace, caller)
 		} else {
@@ -88,3 +425,74 @@ func getTrace() Trace {
 	}
 	return trace
 }
+
+// getCallerTrace captures the call stack like getTrace, starting from the
+// first caller outside this package.
+func getCallerTrace() Trace {
+	trace := getTrace()
+	for k, caller := range trace {
+		if !isInternalFrame(caller) {
+			return trace[k:]
+		}
+	}
+	return trace
+}
+
+// getStack captures the program counters of the call stack for later
+// resolution with resolveStack. The stack is captured into a fixed buffer
+// first, which is only grown while the call stack fills it, so deep limits
+// don't cost shallow call stacks an allocation.
+func getStack() []uintptr {
+	max := MaxTraceDepth()
+	if 0 == max {
+		return nil
+	}
+	var buf [32]uintptr
+	pcs := buf[:]
+	if max < len(pcs) {
+		pcs = pcs[:max]
+	}
+	n := runtime.Callers(1, pcs)
+	for n == len(pcs) && n < max {
+		size := 2 * len(pcs)
+		if size > max {
+			size = max
+		}
+		pcs = make([]uintptr, size)
+		n = runtime.Callers(1, pcs)
+	}
+	return append([]uintptr(nil), pcs[:n]...)
+}
+
+// traceMsg returns msg with the call stack captured, if tracing is
+// enabled and the error is sampled.
+func traceMsg(msg Msg) Msg {
+	if TraceEnabled() && traceSampled() {
+		if LazyTrace() {
+			msg.stack = getStack()
+		} else {
+			msg.trace = getTrace()
+		}
+	}
+	return msg
+}
+
+// resolveStack resolves program counters captured by getStack into a
+// trace.
+func resolveStack(pcs []uintptr) Trace {
+	var trace Trace
+	frames := runtime.CallersFrames(pcs)
+	for max := MaxTraceDepth(); len(trace) < max; {
+		frame, more := frames.Next()
+		trace = append(trace, Call{
+			file: frame.File,
+			line: frame.Line,
+			ok:   true,
+			pc:   frame.PC,
+		})
+		if !more {
+			break
+		}
+	}
+	return trace
+}
@@ -0,0 +1,561 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func deepNew(depth int) *Err {
+	if depth > 0 {
+		return deepNew(depth - 1)
+	}
+	return New(ErrUnknown, "deep")
+}
+
+func TestMaxTraceDepth(t *testing.T) {
+	defer SetMaxTraceDepth(MaxTraceDepth())
+
+	SetMaxTraceDepth(10)
+	if trace := deepNew(50).Last().Trace(); 10 != len(trace) {
+		t.Errorf("Expected 10, received %d", len(trace))
+	}
+
+	SetMaxTraceDepth(0)
+	err := deepNew(50)
+	if trace := err.Last().Trace(); 0 != len(trace) {
+		t.Errorf("Expected 0, received %d", len(trace))
+	}
+	if !err.Caller().Ok() {
+		t.Errorf("Expected the caller to be captured")
+	}
+
+	// Negative depths disable call stack capture
+	SetMaxTraceDepth(-1)
+	if 0 != MaxTraceDepth() {
+		t.Errorf("Expected 0, received %d", MaxTraceDepth())
+	}
+	if trace := deepNew(50).Last().Trace(); 0 != len(trace) {
+		t.Errorf("Expected 0, received %d", len(trace))
+	}
+}
+
+func TestMaxTraceDepthLimit(t *testing.T) {
+	defer SetMaxTraceDepth(MaxTraceDepth())
+
+	SetMaxTraceDepth(1 << 30)
+	if maxTraceDepthLimit != MaxTraceDepth() {
+		t.Errorf("Expected %d, received %d", maxTraceDepthLimit, MaxTraceDepth())
+	}
+
+	// Deep stacks are captured beyond the initial buffer
+	if trace := deepNew(100).Last().Trace(); len(trace) <= 100 {
+		t.Errorf("Expected more than 100 frames, received %d", len(trace))
+	}
+
+	// Shallow stacks don't pay for a deep limit
+	deep := testing.AllocsPerRun(100, func() { New(ErrUnknown, "new") })
+	SetMaxTraceDepth(32)
+	shallow := testing.AllocsPerRun(100, func() { New(ErrUnknown, "new") })
+	if deep != shallow {
+		t.Errorf("Expected %v allocations, received %v", shallow, deep)
+	}
+}
+
+func benchmarkNew(b *testing.B, depth int) {
+	defer SetMaxTraceDepth(MaxTraceDepth())
+	SetMaxTraceDepth(depth)
+
+	b.ReportAllocs()
+	for a := 0; a < b.N; a++ {
+		deepNew(100)
+	}
+}
+
+func BenchmarkNew(b *testing.B) {
+	benchmarkNew(b, 32)
+}
+
+func BenchmarkNewUncapped(b *testing.B) {
+	benchmarkNew(b, 1024)
+}
+
+func BenchmarkNewNoTrace(b *testing.B) {
+	benchmarkNew(b, 0)
+}
+
+func benchmarkFormat(b *testing.B, cached bool) {
+	err := New(ErrUnknown, "root")
+	for a := 0; a < 5; a++ {
+		err = Wrap(err, ErrUnknown, "wrapped")
+	}
+
+	b.ReportAllocs()
+	for a := 0; a < b.N; a++ {
+		if !cached {
+			funcNames.Range(func(key, _ interface{}) bool {
+				funcNames.Delete(key)
+				return true
+			})
+		}
+		_ = fmt.Sprintf("%+v", err)
+	}
+}
+
+func BenchmarkFormat(b *testing.B) {
+	benchmarkFormat(b, true)
+}
+
+func BenchmarkFormatUncached(b *testing.B) {
+	benchmarkFormat(b, false)
+}
+
+func TestLazyTrace(t *testing.T) {
+	defer SetLazyTrace(LazyTrace())
+
+	SetLazyTrace(false)
+	if LazyTrace() {
+		t.Fatalf("Expected lazy traces to be disabled")
+	}
+	eager := deepNew(5).Last().Trace()
+	SetLazyTrace(true)
+	if !LazyTrace() {
+		t.Fatalf("Expected lazy traces to be enabled")
+	}
+	lazy := deepNew(5).Last().Trace()
+
+	if len(eager) != len(lazy) {
+		t.Fatalf("Expected %d frames, received %d", len(eager), len(lazy))
+	}
+	// The first frame is the capture function itself and line numbers
+	// differ between the two call sites, so compare files and functions
+	for k := 1; k < len(eager); k++ {
+		expect := fmt.Sprintf("%s %s", eager[k].File(), runtime.FuncForPC(eager[k].Pc()).Name())
+		received := fmt.Sprintf("%s %s", lazy[k].File(), runtime.FuncForPC(lazy[k].Pc()).Name())
+		if expect != received {
+			t.Errorf("Expected '%s' at %d, received '%s'", expect, k, received)
+		}
+	}
+}
+
+func benchmarkLazyTrace(b *testing.B, lazy bool) {
+	defer SetLazyTrace(LazyTrace())
+	SetLazyTrace(lazy)
+
+	b.ReportAllocs()
+	for a := 0; a < b.N; a++ {
+		deepNew(10)
+	}
+}
+
+func BenchmarkNewEagerTrace(b *testing.B) {
+	benchmarkLazyTrace(b, false)
+}
+
+func BenchmarkNewLazyTrace(b *testing.B) {
+	benchmarkLazyTrace(b, true)
+}
+
+func TestSetTraceEnabled(t *testing.T) {
+	defer SetTraceEnabled(TraceEnabled())
+
+	SetTraceEnabled(false)
+	if TraceEnabled() {
+		t.Fatalf("Expected traces to be disabled")
+	}
+	err := Wrap(New(ErrUnknown, "new"), ErrFatal, "wrap")
+	if nil != err.errs[0].Trace() {
+		t.Errorf("Expected no trace, received %v", err.errs[0].Trace())
+	}
+	if !err.Caller().Ok() || "caller_test.go" != path.Base(err.Caller().File()) {
+		t.Errorf("Expected the caller to be captured, received %v", err.Caller())
+	}
+	if 2 != len(err.Trace()) {
+		t.Errorf("Expected 2 callers, received %d", len(err.Trace()))
+	}
+	_ = fmt.Sprintf("%+v", err)
+
+	SetTraceEnabled(true)
+	if !TraceEnabled() {
+		t.Fatalf("Expected traces to be enabled")
+	}
+	if 0 == len(New(ErrUnknown, "new").Last().Trace()) {
+		t.Errorf("Expected a trace")
+	}
+}
+
+func benchmarkTraceEnabled(b *testing.B, enabled bool) {
+	defer SetTraceEnabled(TraceEnabled())
+	SetTraceEnabled(enabled)
+
+	b.ReportAllocs()
+	for a := 0; a < b.N; a++ {
+		deepNew(10)
+	}
+}
+
+func BenchmarkNewTraceEnabled(b *testing.B) {
+	benchmarkTraceEnabled(b, true)
+}
+
+func BenchmarkNewTraceDisabled(b *testing.B) {
+	benchmarkTraceEnabled(b, false)
+}
+
+func TestWithStack(t *testing.T) {
+	done := make(chan *Err)
+	go func() {
+		done <- New(ErrFatal, "worker failed")
+	}()
+	err := <-done
+	_, _, line, _ := runtime.Caller(0)
+	err.WithStack()
+
+	trace := err.Last().Trace()
+	if 0 == len(trace) {
+		t.Fatalf("Expected a trace to be captured")
+	}
+	if "caller_test.go" != path.Base(trace[0].File()) || line+1 != trace[0].Line() {
+		t.Errorf("Expected caller_test.go:%d, received %s:%d", line+1, path.Base(trace[0].File()), trace[0].Line())
+	}
+	if "worker failed" != err.Error() || ErrFatal != err.Code() {
+		t.Errorf("Expected the frame to be unchanged, received '%s' (code:%d)", err.Error(), err.Code())
+	}
+}
+
+// newHelper and wrapHelper simulate helper functions that create errors on
+// behalf of their callers.
+func newHelper(skip int) *Err {
+	return NewSkip(skip, ErrUnknown, "helper")
+}
+
+func wrapHelper(skip int) *Err {
+	return WrapSkip(skip, fmt.Errorf("cause"), ErrUnknown, "helper")
+}
+
+func TestNewSkip(t *testing.T) {
+	tests := []struct {
+		err  *Err
+		fn   string
+		file string
+	}{
+		{newHelper(0), "newHelper", "caller_test.go"},
+		{newHelper(1), "TestNewSkip", "caller_test.go"},
+		{newHelper(2), "tRunner", "testing.go"},
+		{wrapHelper(0), "wrapHelper", "caller_test.go"},
+		{wrapHelper(1), "TestNewSkip", "caller_test.go"},
+		{wrapHelper(2), "tRunner", "testing.go"},
+	}
+	for _, test := range tests {
+		caller := test.err.Caller()
+		if fn := callerFunc(caller); !strings.HasSuffix(fn, "."+test.fn) {
+			t.Errorf("Expected '%s', received '%s'", test.fn, fn)
+		}
+		if test.file != path.Base(caller.File()) {
+			t.Errorf("Expected '%s', received '%s'", test.file, path.Base(caller.File()))
+		}
+	}
+
+	// Skipping past the top of the stack
+	if caller := newHelper(1000).Caller(); caller.Ok() {
+		t.Errorf("Expected no caller, received %s", callerText(caller))
+	}
+}
+
+func TestIsPackageFrame(t *testing.T) {
+	if "github.com/lkcloud/errors" != pkgName {
+		t.Errorf("Expected 'github.com/lkcloud/errors', received '%s'", pkgName)
+	}
+
+	tests := []struct {
+		pkg    string
+		file   string
+		fn     string
+		expect bool
+	}{
+		// Module layout
+		{pkgName, "/go/pkg/mod/github.com/lkcloud/errors@v1.0.0/err.go", "github.com/lkcloud/errors.(*Err).Wrap", true},
+		{pkgName, "/go/pkg/mod/github.com/lkcloud/errors@v1.0.0/err.go", "github.com/lkcloud/errors.Join.func1", true},
+		{pkgName, "/src/app/main.go", "main.main", false},
+		// Vendored layout
+		{"app/vendor/github.com/lkcloud/errors", "/src/app/vendor/github.com/lkcloud/errors/err.go", "app/vendor/github.com/lkcloud/errors.New", true},
+		{"app/vendor/github.com/lkcloud/errors", "/src/app/handler.go", "app/handler.(*Server).ServeHTTP", false},
+		// Forks
+		{"example.com/fork/errors", "/src/fork/errors/err.go", "example.com/fork/errors.wrap", true},
+		// Similarly named packages
+		{pkgName, "/src/errors/sub/sub.go", "github.com/lkcloud/errors/sub.New", false},
+		{pkgName, "/src/errors/example_test.go", "github.com/lkcloud/errors_test.Example", false},
+		// Tests
+		{pkgName, "/src/errors/err_test.go", "github.com/lkcloud/errors.TestWrap", false},
+	}
+	for _, test := range tests {
+		if test.expect != isPackageFrame(test.pkg, test.file, test.fn) {
+			t.Errorf("Expected %t for '%s', received %t", test.expect, test.fn, !test.expect)
+		}
+	}
+}
+
+// mypkgNew simulates a framework helper that creates errors.
+func mypkgNew() *Err {
+	return New(ErrUnknown, "framework")
+}
+
+func TestSetCallerSkipFunc(t *testing.T) {
+	err := mypkgNew()
+	if fn := callerFunc(err.Caller()); !strings.HasSuffix(fn, ".mypkgNew") {
+		t.Errorf("Expected 'mypkgNew', received '%s'", fn)
+	}
+
+	defer SetCallerSkipFunc(nil)
+	SetCallerSkipFunc(func(file, funcName string) bool {
+		return strings.HasPrefix(funcName[strings.LastIndex(funcName, ".")+1:], "mypkg")
+	})
+	err = mypkgNew()
+	if fn := callerFunc(err.Caller()); !strings.HasSuffix(fn, ".TestSetCallerSkipFunc") {
+		t.Errorf("Expected 'TestSetCallerSkipFunc', received '%s'", fn)
+	}
+}
+
+func TestSetTrimPrefix(t *testing.T) {
+	err := New(ErrUnknown, "trimmed")
+	file, line := err.Caller().File(), err.Caller().Line()
+	dir := path.Dir(file)
+
+	defer SetTrimPrefix("")
+	tests := []struct {
+		prefix string
+		expect string
+	}{
+		{"", fmt.Sprintf("\tline:    caller_test.go:%d\n", line)},
+		{path.Dir(dir), fmt.Sprintf("\tline:    %s/caller_test.go:%d\n", path.Base(dir), line)},
+		{path.Dir(dir) + "/", fmt.Sprintf("\tline:    %s/caller_test.go:%d\n", path.Base(dir), line)},
+		{"/not/a/prefix", fmt.Sprintf("\tline:    caller_test.go:%d\n", line)},
+	}
+	for _, test := range tests {
+		SetTrimPrefix(test.prefix)
+		if str := fmt.Sprintf("%+v", err); !strings.Contains(str, test.expect) {
+			t.Errorf("Expected '%s' with prefix '%s', received '%s'", strings.TrimSpace(test.expect), test.prefix, str)
+		}
+	}
+}
+
+func TestSetShortFuncNames(t *testing.T) {
+	err := New(ErrUnknown, "short")
+	line := err.Caller().Line()
+
+	defer SetShortFuncNames(false)
+	tests := []struct {
+		short  bool
+		expect string
+	}{
+		{false, fmt.Sprintf(`#0 - caller: "caller_test.go:%d:github.com/lkcloud/errors.TestSetShortFuncNames" error: "short" detail: "short (code:1)"`, line)},
+		{true, fmt.Sprintf(`#0 - caller: "caller_test.go:%d:TestSetShortFuncNames" error: "short" detail: "short (code:1)"`, line)},
+	}
+	for _, test := range tests {
+		SetShortFuncNames(test.short)
+		if str := fmt.Sprintf("%#v", err); test.expect != str {
+			t.Errorf("Expected '%s', received '%s'", test.expect, str)
+		}
+	}
+
+	// Methods and closures keep their receiver and function names
+	SetShortFuncNames(true)
+	if name := funcName(Call{pc: reflect.ValueOf((*Err).Clone).Pointer()}); "(*Err).Clone" != name {
+		t.Errorf("Expected '(*Err).Clone', received '%s'", name)
+	}
+}
+
+func TestTraceOrder(t *testing.T) {
+	root := New(ErrUnknown, "root")
+	middle := Wrap(root, ErrUnknown, "middle")
+	top := Wrap(middle, ErrUnknown, "top")
+
+	lines := []int{root.Caller().Line(), middle.Caller().Line(), top.Caller().Line()}
+	if lines[0] == lines[1] || lines[1] == lines[2] {
+		t.Fatalf("Expected frames on separate lines, received %v", lines)
+	}
+
+	trace := top.Trace()
+	reversed := top.TraceReversed()
+	if 3 != len(trace) || 3 != len(reversed) {
+		t.Fatalf("Expected 3 callers, received %d and %d", len(trace), len(reversed))
+	}
+	for k, line := range lines {
+		if line != trace[k].Line() {
+			t.Errorf("Expected line %d at %d, received %d", line, k, trace[k].Line())
+		}
+		if line != reversed[2-k].Line() {
+			t.Errorf("Expected line %d at %d, received %d", line, 2-k, reversed[2-k].Line())
+		}
+	}
+
+	// The reversed trace follows the formatted output
+	if !strings.HasPrefix(fmt.Sprintf("%#v", top), "#2 - caller: \""+callerText(reversed[0])) {
+		t.Errorf("Expected the formatted trace to start with %s, received %#v", callerText(reversed[0]), top)
+	}
+}
+
+func TestFullTrace(t *testing.T) {
+	defer SetTraceEnabled(TraceEnabled())
+	SetTraceEnabled(true)
+
+	// Wrap captures a trace for its frame
+	err := Wrap(fmt.Errorf("cause"), ErrUnknown, "wrapped")
+	if 0 == len(err.Last().Trace()) {
+		t.Errorf("Expected Wrap to capture a trace")
+	}
+	if 2 != len(err.Trace()) {
+		t.Errorf("Expected 2 callers, received %d", len(err.Trace()))
+	}
+	if !reflect.DeepEqual(err.Last().Trace(), err.FullTrace()) {
+		t.Errorf("Expected the wrapping frame's trace, received %v", err.FullTrace())
+	}
+
+	// The deepest trace is returned
+	root := deepNew(5)
+	err = Wrap(Wrap(root, ErrUnknown, "middle"), ErrUnknown, "top")
+	if 3 != len(err.Trace()) {
+		t.Errorf("Expected 3 callers, received %d", len(err.Trace()))
+	}
+	if !reflect.DeepEqual(root.Last().Trace(), err.FullTrace()) {
+		t.Errorf("Expected the root trace, received %v", err.FullTrace())
+	}
+	if len(err.FullTrace()) <= len(err.Last().Trace()) {
+		t.Errorf("Expected the root trace to be deeper than the top frame's")
+	}
+
+	SetTraceEnabled(false)
+	if trace := Wrap(New(ErrUnknown, "new"), ErrUnknown, "wrap").FullTrace(); nil != trace {
+		t.Errorf("Expected no trace, received %v", trace)
+	}
+}
+
+func TestCallerFunction(t *testing.T) {
+	err := New(ErrUnknown, "new")
+	if expect := pkgName + ".TestCallerFunction"; expect != err.Caller().Function() {
+		t.Errorf("Expected '%s', received '%s'", expect, err.Caller().Function())
+	}
+	if "" != (Call{}).Function() {
+		t.Errorf("Expected no function name, received '%s'", (Call{}).Function())
+	}
+}
+
+func TestWithCaller(t *testing.T) {
+	defer SetShortFuncNames(false)
+	defer SetTrimPrefix("")
+	SetTrimPrefix("/srv/billing")
+
+	err := New(ErrUnknown, "charge declined").
+		WithCaller("/srv/billing/internal/charge.go", 42, "example.com/billing/internal.Charge")
+	caller := err.Caller()
+	if 0 != caller.Pc() || !caller.Ok() {
+		t.Errorf("Expected a synthetic caller, received pc %d ok %t", caller.Pc(), caller.Ok())
+	}
+	if "example.com/billing/internal.Charge" != caller.Function() {
+		t.Errorf("Expected 'example.com/billing/internal.Charge', received '%s'", caller.Function())
+	}
+
+	expect := `#0 - caller: "internal/charge.go:42:example.com/billing/internal.Charge" error: "charge declined" detail: "charge declined (code:1)"`
+	if str := fmt.Sprintf("%#v", err); expect != str {
+		t.Errorf("Expected '%s', received '%s'", expect, str)
+	}
+
+	SetShortFuncNames(true)
+	str := fmt.Sprintf("%+v", err)
+	if !strings.Contains(str, "#0: `Charge`") || !strings.Contains(str, "line:    internal/charge.go:42") {
+		t.Errorf("Expected the synthetic caller, received '%s'", str)
+	}
+}
+
+func TestSetTraceSampler(t *testing.T) {
+	defer SetTraceSampler(nil)
+
+	calls := 0
+	SetTraceSampler(func() bool {
+		calls++
+		return 1 == calls%2
+	})
+	sampled := New(ErrUnknown, "sampled")
+	skipped := Wrap(errors.New("cause"), ErrUnknown, "skipped")
+
+	if 0 == len(sampled.Last().Trace()) {
+		t.Errorf("Expected a trace for a sampled error")
+	}
+	if 0 != len(skipped.Last().Trace()) || nil != skipped.FullTrace() {
+		t.Errorf("Expected no trace for an error that wasn't sampled")
+	}
+	if !skipped.Caller().Ok() || "caller_test.go" != path.Base(skipped.Caller().File()) {
+		t.Errorf("Expected the caller to be captured, received %v", skipped.Caller())
+	}
+	if 2 != len(skipped.Trace()) {
+		t.Errorf("Expected 2 callers, received %d", len(skipped.Trace()))
+	}
+	if !strings.Contains(fmt.Sprintf("%+v", skipped), "line:    caller_test.go:") {
+		t.Errorf("Expected the formatted trace to show callers, received %+v", skipped)
+	}
+
+	// The sampler isn't called while tracing is disabled
+	defer SetTraceEnabled(TraceEnabled())
+	SetTraceEnabled(false)
+	New(ErrUnknown, "disabled")
+	if 2 != calls {
+		t.Errorf("Expected 2 sampler calls, received %d", calls)
+	}
+}
+
+func TestCallMarshalText(t *testing.T) {
+	caller := New(ErrUnknown, "new").Caller().(Call)
+	text, e := caller.MarshalText()
+	if nil != e {
+		t.Fatalf("Expected nil, received %s", e)
+	}
+	expect := fmt.Sprintf("%s:%d:%s", caller.File(), caller.Line(), pkgName+".TestCallMarshalText")
+	if expect != string(text) {
+		t.Errorf("Expected '%s', received '%s'", expect, text)
+	}
+
+	var restored Call
+	if e := restored.UnmarshalText(text); nil != e {
+		t.Fatalf("Expected nil, received %s", e)
+	}
+	if caller.File() != restored.File() || caller.Line() != restored.Line() || caller.Function() != restored.Function() {
+		t.Errorf("Expected %s, received %s", caller, restored)
+	}
+	if restored.Ok() {
+		t.Errorf("Expected a restored caller to not be Ok")
+	}
+	if again, _ := restored.MarshalText(); string(text) != string(again) {
+		t.Errorf("Expected '%s', received '%s'", text, again)
+	}
+
+	tests := []struct {
+		text     string
+		file     string
+		line     int
+		function string
+	}{
+		{"/srv/app/main.go:7", "/srv/app/main.go", 7, ""},
+		{"/srv/app/main.go:7:", "/srv/app/main.go", 7, ""},
+		{"C:/app/main.go:7:main.main", "C:/app/main.go", 7, "main.main"},
+	}
+	for _, test := range tests {
+		var call Call
+		if e := call.UnmarshalText([]byte(test.text)); nil != e {
+			t.Errorf("Expected nil for '%s', received %s", test.text, e)
+		}
+		if test.file != call.File() || test.line != call.Line() || test.function != call.Function() {
+			t.Errorf("Expected %s:%d %s, received %s:%d %s", test.file, test.line, test.function, call.File(), call.Line(), call.Function())
+		}
+	}
+
+	for _, text := range []string{"", "main.go", "main.go:main.main", "main.go:x:main.main"} {
+		var call Call
+		if e := call.UnmarshalText([]byte(text)); nil == e {
+			t.Errorf("Expected an error for '%s'", text)
+		}
+	}
+}
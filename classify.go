@@ -0,0 +1,111 @@
+package errors
+
+import (
+	"errors"
+)
+
+/*
+IsRetryable reports whether err can be retried. For an *Err, frames are
+checked from the most recent to the root cause:
+
+ 1. A frame marked with WithRetryable returns the mark.
+ 2. A frame whose code metadata has Retryable set returns true.
+
+If no frame is retryable, IsRetryable falls back to Temporary(). Any
+other error is retryable if it implements Temporary() bool and reports
+true.
+*/
+func IsRetryable(err error) bool {
+	var e *Err
+	if !errors.As(err, &e) {
+		var t interface{ Temporary() bool }
+		return errors.As(err, &t) && t.Temporary()
+	}
+
+	errs := e.stack()
+	for k := len(errs) - 1; k >= 0; k-- {
+		if msg, ok := errs[k].(Msg); ok && nil != msg.retryable {
+			return *msg.retryable
+		}
+		if code, ok := LookupCode(errs[k].Code()); ok {
+			if coder, ok := code.(interface{ IsRetryable() bool }); ok && coder.IsRetryable() {
+				return true
+			}
+		}
+	}
+	return e.Temporary()
+}
+
+// Temporary reports whether the error is temporary. If a frame has been
+// marked with WithTemporary, the most recent mark is returned. Otherwise
+// the underlying error of each frame is checked, most recent first, for a
+// Temporary() bool method.
+func (err *Err) Temporary() bool {
+	errs := err.stack()
+	for k := len(errs) - 1; k >= 0; k-- {
+		if msg, ok := errs[k].(Msg); ok && nil != msg.temporary {
+			return *msg.temporary
+		}
+		var e interface{ Temporary() bool }
+		if unwrapAs(errs[k], &e) {
+			return e.Temporary()
+		}
+	}
+	return false
+}
+
+// Timeout reports whether the error is a timeout. If a frame has been
+// marked with WithTimeout, the most recent mark is returned. Otherwise
+// the underlying error of each frame is checked, most recent first, for a
+// Timeout() bool method.
+func (err *Err) Timeout() bool {
+	errs := err.stack()
+	for k := len(errs) - 1; k >= 0; k-- {
+		if msg, ok := errs[k].(Msg); ok && nil != msg.timeout {
+			return *msg.timeout
+		}
+		var e interface{ Timeout() bool }
+		if unwrapAs(errs[k], &e) {
+			return e.Timeout()
+		}
+	}
+	return false
+}
+
+// WithRetryable marks the most recent error in the stack as retryable or
+// not, overriding the error code and any underlying error.
+func (err *Err) WithRetryable(retryable bool) *Err {
+	return err.updateLast(func(msg Msg) Msg {
+		msg.retryable = &retryable
+		return msg
+	})
+}
+
+// WithTemporary marks the most recent error in the stack as temporary or
+// not, overriding any underlying error.
+func (err *Err) WithTemporary(temporary bool) *Err {
+	return err.updateLast(func(msg Msg) Msg {
+		msg.temporary = &temporary
+		return msg
+	})
+}
+
+// WithTimeout marks the most recent error in the stack as a timeout or
+// not, overriding any underlying error.
+func (err *Err) WithTimeout(timeout bool) *Err {
+	return err.updateLast(func(msg Msg) Msg {
+		msg.timeout = &timeout
+		return msg
+	})
+}
+
+// unwrapAs reports whether the underlying error of a frame matches target
+// using errors.As.
+func unwrapAs(msg ErrMsg, target interface{}) bool {
+	if msg, ok := msg.(interface{ Unwrap() error }); ok {
+		if e := msg.Unwrap(); nil != e {
+			return errors.As(e, target)
+		}
+	}
+	return false
+}
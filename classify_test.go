@@ -0,0 +1,83 @@
+package errors
+
+import (
+	"net"
+	"testing"
+)
+
+type netError struct {
+	temporary bool
+	timeout   bool
+}
+
+func (err netError) Error() string   { return "net error" }
+func (err netError) Temporary() bool { return err.temporary }
+func (err netError) Timeout() bool   { return err.timeout }
+
+var _ net.Error = netError{}
+
+func TestTemporary(t *testing.T) {
+	// Propagated from a wrapped net.Error
+	err := Wrap(netError{temporary: true, timeout: true}, ErrUnknown, "dial failed")
+	err = Wrap(err, ErrFatal, "request failed")
+	if !err.Temporary() {
+		t.Errorf("Expected a temporary error")
+	}
+	if !err.Timeout() {
+		t.Errorf("Expected a timeout error")
+	}
+
+	// Marks on the most recent frame take precedence
+	err = err.WithTemporary(false).WithTimeout(false)
+	if err.Temporary() {
+		t.Errorf("Expected a permanent error")
+	}
+	if err.Timeout() {
+		t.Errorf("Expected no timeout")
+	}
+
+	err = New(ErrUnknown, "plain")
+	if err.Temporary() || err.Timeout() {
+		t.Errorf("Expected a plain error to be neither temporary nor a timeout")
+	}
+	if !err.WithTemporary(true).Temporary() {
+		t.Errorf("Expected a temporary error")
+	}
+	if !err.WithTimeout(true).Timeout() {
+		t.Errorf("Expected a timeout error")
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	if IsRetryable(nil) {
+		t.Errorf("Expected nil to not be retryable")
+	}
+	if !IsRetryable(netError{temporary: true}) {
+		t.Errorf("Expected a temporary net.Error to be retryable")
+	}
+
+	// Per-error retryability
+	err := New(ErrUnknown, "failed")
+	if IsRetryable(err) {
+		t.Errorf("Expected a plain error to not be retryable")
+	}
+	if !IsRetryable(err.WithRetryable(true)) {
+		t.Errorf("Expected a marked error to be retryable")
+	}
+
+	// Per-code retryability
+	setTestCode(t, errTestCode, ErrCode{Ext: "unavailable", HTTP: 503, Retryable: true})
+	err = Wrap(New(errTestCode, "unavailable"), ErrFatal, "request failed")
+	if !IsRetryable(err) {
+		t.Errorf("Expected a retryable code to make the error retryable")
+	}
+	if IsRetryable(err.WithRetryable(false)) {
+		t.Errorf("Expected a mark to override the code")
+	}
+
+	// Wrapped temporary errors
+	err = Wrap(netError{temporary: true}, ErrUnknown, "dial failed")
+	if !IsRetryable(err) {
+		t.Errorf("Expected a wrapped temporary error to be retryable")
+	}
+}
@@ -0,0 +1,54 @@
+package errors
+
+import (
+	"sort"
+	"sync"
+)
+
+// CodeRange defines a named family of error codes, such as encoding or
+// authentication errors. Min and Max are both inclusive.
+type CodeRange struct {
+	Min  Code
+	Max  Code
+	Name string
+}
+
+// Contains returns whether code is within the range.
+func (r CodeRange) Contains(code Code) bool {
+	return code >= r.Min && code <= r.Max
+}
+
+// codeRanges contains the registered code ranges, sorted by Min.
+var codeRanges = []CodeRange{}
+var rangesMux = &sync.RWMutex{}
+
+// RegisterRange registers a family of error codes for ClassifyCode. An
+// error with the ErrInvalidRange code is returned if Min is greater than
+// Max or the range overlaps a range that is already registered.
+func RegisterRange(r CodeRange) error {
+	if r.Min > r.Max {
+		return New(ErrInvalidRange, "code range %q is not valid, %d is greater than %d", r.Name, r.Min, r.Max)
+	}
+
+	rangesMux.Lock()
+	defer rangesMux.Unlock()
+	for _, existing := range codeRanges {
+		if r.Min <= existing.Max && r.Max >= existing.Min {
+			return New(ErrInvalidRange, "code range %q overlaps code range %q", r.Name, existing.Name)
+		}
+	}
+	codeRanges = append(codeRanges, r)
+	sort.Slice(codeRanges, func(i, j int) bool { return codeRanges[i].Min < codeRanges[j].Min })
+	return nil
+}
+
+// ClassifyCode returns the registered range that contains code, if any.
+func ClassifyCode(code Code) (CodeRange, bool) {
+	rangesMux.RLock()
+	defer rangesMux.RUnlock()
+	k := sort.Search(len(codeRanges), func(i int) bool { return codeRanges[i].Max >= code })
+	if k < len(codeRanges) && codeRanges[k].Contains(code) {
+		return codeRanges[k], true
+	}
+	return CodeRange{}, false
+}
@@ -0,0 +1,62 @@
+package errors
+
+import (
+	"testing"
+)
+
+func TestClassifyCode(t *testing.T) {
+	defer func(ranges []CodeRange) {
+		codeRanges = ranges
+	}(append([]CodeRange(nil), codeRanges...))
+
+	if err := RegisterRange(CodeRange{Min: 2000, Max: 2999, Name: "auth"}); nil != err {
+		t.Fatalf("Expected nil, received %s", err)
+	}
+	if err := RegisterRange(CodeRange{Min: 1000, Max: 1999, Name: "storage"}); nil != err {
+		t.Fatalf("Expected nil, received %s", err)
+	}
+
+	tests := []struct {
+		code Code
+		name string
+		ok   bool
+	}{
+		{ErrSuccess, "success", true},
+		{ErrFatal, "internal", true},
+		{ErrDecodingJSON, "encoding", true},
+		{999, "", false},
+		{1000, "storage", true},
+		{1999, "storage", true},
+		{2000, "auth", true},
+		{2999, "auth", true},
+		{3000, "", false},
+		{-1, "", false},
+	}
+	for _, test := range tests {
+		r, ok := ClassifyCode(test.code)
+		if test.ok != ok || test.name != r.Name {
+			t.Errorf("Expected '%s', %t for %d, received '%s', %t", test.name, test.ok, test.code, r.Name, ok)
+		}
+	}
+
+	// Overlapping ranges
+	err := RegisterRange(CodeRange{Min: 2999, Max: 3999, Name: "billing"})
+	if nil == err {
+		t.Fatalf("Expected an error registering an overlapping range")
+	}
+	if ErrInvalidRange != err.(*Err).Code() {
+		t.Errorf("Expected %d, received %d", ErrInvalidRange, err.(*Err).Code())
+	}
+
+	// Invalid ranges
+	err = RegisterRange(CodeRange{Min: 4999, Max: 4000, Name: "invalid"})
+	if nil == err {
+		t.Fatalf("Expected an error registering an invalid range")
+	}
+	if ErrInvalidRange != err.(*Err).Code() {
+		t.Errorf("Expected %d, received %d", ErrInvalidRange, err.(*Err).Code())
+	}
+	if _, ok := ClassifyCode(4500); ok {
+		t.Errorf("Expected the invalid range not to be registered")
+	}
+}
@@ -1,5 +1,14 @@
 package errors
 
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+)
+
 // Code defines an error code type.
 type Code int
 
@@ -17,8 +26,20 @@ const (
 	ErrFatal
 	// ErrCodeNotFound - 3: Code not found
 	ErrCodeNotFound
+	// ErrCodeExists - 4: Code already registered
+	ErrCodeExists
+	// ErrReservedCode - 5: Code is reserved for the errors package
+	ErrReservedCode
+	// ErrInvalidCode - 6: Code metadata is not valid
+	ErrInvalidCode
+	// ErrInvalidRange - 7: Code range is not valid
+	ErrInvalidRange
 )
 
+// MinUserCode is the lowest code available outside this package. Codes
+// below MinUserCode are reserved for the package's built-in codes.
+const MinUserCode Code = 1000
+
 // Encoding errors
 const (
 	// ErrDecodingFailed - Decoding failed due to an error with the data.
@@ -47,8 +68,73 @@ const (
 	ErrTypeConversionFailed
 )
 
+// Error implements the error interface, returning the external error
+// text for the code. This allows a *Code to be used as an errors.As
+// target.
+func (code Code) Error() string {
+	if coder, ok := LookupCode(code); ok {
+		return coder.String()
+	}
+	return fmt.Sprintf("code:%d", code)
+}
+
 func (code Code) AsError() *Err {
-	return New(code, Codes[code].String())
+	msg := ""
+	if coder, ok := LookupCode(code); ok {
+		msg = coder.String()
+	}
+	return New(code, msg)
+}
+
+// Is reports whether target is the same code. Is implements the interface
+// used by errors.Is, so it takes an error rather than a Code: a method
+// named Is with any other signature would not be called by errors.Is.
+// Since Code implements error, Is can still be called with another code
+// directly, such as code.Is(ErrInvalidJSON). Use HasCode to check whether
+// any frame of an error stack carries a code.
+func (code Code) Is(target error) bool {
+	other, ok := target.(Code)
+	return ok && code == other
+}
+
+// CodeOf returns the leading code of err. ErrSuccess is returned for a nil
+// error and ErrUnknown for errors that aren't an *Err.
+func CodeOf(err error) Code {
+	if nil == err {
+		return ErrSuccess
+	}
+	if e, ok := err.(*Err); ok {
+		return e.Code()
+	}
+	return ErrUnknown
+}
+
+// HasCode reports whether any frame in the error stack of err carries
+// code. err may be an *Err, a Msg or an error wrapping an *Err.
+func HasCode(err error, code Code) bool {
+	if msg, ok := err.(Msg); ok {
+		return code.Is(msg.Code())
+	}
+	var e *Err
+	if !errors.As(err, &e) {
+		return false
+	}
+	for _, msg := range e.stack() {
+		if code.Is(msg.Code()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Name returns the identifier registered for the code with
+// RegisterCodeName, such as "ErrInvalidJSON", or an empty string if none
+// is registered.
+func (code Code) Name() string {
+	codesMux.RLock()
+	name := codeNames[code]
+	codesMux.RUnlock()
+	return name
 }
 
 func (code Code) New(msg string, data ...interface{}) *Err {
@@ -69,8 +155,203 @@ type Coder interface {
 	String() string
 }
 
-// Codes contains a map of error codes to metadata
+// Codes contains a map of error codes to metadata. Access to Codes is
+// guarded by LookupCode and SetCode, which should be used in preference to
+// reading or writing the map directly.
 var Codes = map[Code]Coder{}
+var codesMux = &sync.RWMutex{}
+
+// LookupCode returns the metadata registered for code, if any.
+func LookupCode(code Code) (Coder, bool) {
+	codesMux.RLock()
+	c, ok := Codes[code]
+	codesMux.RUnlock()
+	return c, ok
+}
+
+// SetCode sets the metadata for code, replacing any existing metadata.
+// Unlike RegisterCode, metadata already registered for code may be
+// replaced, but the other checks are the same: an error is returned and
+// nothing is set if code is below MinUserCode, the HTTP status of c is
+// outside 100-599 or c implements GRPCCoder with an unknown gRPC status.
+func SetCode(code Code, c Coder) error {
+	if err := validateMetadata(code, c); nil != err {
+		return err
+	}
+	setCode(code, c)
+	return nil
+}
+
+// setCode sets the metadata for code without validation.
+func setCode(code Code, c Coder) {
+	codesMux.Lock()
+	Codes[code] = c
+	codesMux.Unlock()
+}
+
+// defaultCode is the code of frames created for errors that don't specify
+// one.
+var defaultCode = ErrUnknown
+
+// DefaultCode returns the code given to frames that don't specify one, such
+// as frames added by With and the frame created when Wrap wraps an error
+// that isn't an *Err.
+func DefaultCode() Code {
+	codesMux.RLock()
+	code := defaultCode
+	codesMux.RUnlock()
+	return code
+}
+
+// SetDefaultCode sets the code given to frames that don't specify one. The
+// default is ErrUnknown.
+func SetDefaultCode(code Code) {
+	codesMux.Lock()
+	defaultCode = code
+	codesMux.Unlock()
+}
+
+// CodeInfo describes a registered error code.
+type CodeInfo struct {
+	Code       Code
+	External   string
+	Internal   string
+	HTTPStatus int
+}
+
+// AllCodes returns a description of every registered error code, sorted
+// by code.
+func AllCodes() []CodeInfo {
+	codesMux.RLock()
+	codes := make([]CodeInfo, 0, len(Codes))
+	for code, c := range Codes {
+		codes = append(codes, CodeInfo{
+			Code:       code,
+			External:   c.String(),
+			Internal:   c.Detail(),
+			HTTPStatus: c.HTTPStatus(),
+		})
+	}
+	codesMux.RUnlock()
+	sort.Slice(codes, func(i, j int) bool { return codes[i].Code < codes[j].Code })
+	return codes
+}
+
+// codeNames contains a map of error codes to identifiers.
+var codeNames = map[Code]string{}
+
+// RegisterCodeName sets the identifier returned by code.Name(), replacing
+// any existing identifier.
+func RegisterCodeName(code Code, name string) {
+	codesMux.Lock()
+	codeNames[code] = name
+	codesMux.Unlock()
+}
+
+// RegisterCode adds metadata for code to the Codes map. An error is
+// returned if code is below MinUserCode, the HTTP status of c is outside
+// 100-599, c implements GRPCCoder with an unknown gRPC status, or different
+// metadata is already registered for the code.
+func RegisterCode(code Code, c Coder) error {
+	codesMux.Lock()
+	defer codesMux.Unlock()
+	if err := validateCode(code, c); nil != err {
+		return err
+	}
+	Codes[code] = c
+	return nil
+}
+
+// MustRegisterCode is like RegisterCode but panics if the code can't be
+// registered.
+func MustRegisterCode(code Code, c Coder) {
+	if err := RegisterCode(code, c); nil != err {
+		panic(err)
+	}
+}
+
+// RegisterCodes registers metadata for each code in codes. If any code
+// can't be registered an error is returned and none of the codes are
+// registered.
+func RegisterCodes(codes map[Code]Coder) error {
+	keys := make([]Code, 0, len(codes))
+	for code := range codes {
+		keys = append(keys, code)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	codesMux.Lock()
+	defer codesMux.Unlock()
+	for _, code := range keys {
+		if err := validateCode(code, codes[code]); nil != err {
+			return err
+		}
+	}
+	for _, code := range keys {
+		Codes[code] = codes[code]
+	}
+	return nil
+}
+
+// validateCode returns an error if c can't be registered for code. The
+// caller must hold the codes lock.
+func validateCode(code Code, c Coder) error {
+	if err := validateMetadata(code, c); nil != err {
+		return err
+	}
+	if existing, ok := Codes[code]; ok && !reflect.DeepEqual(existing, c) {
+		return New(ErrCodeExists, "code %d is already registered", code)
+	}
+	return nil
+}
+
+// validateMetadata returns an error if code is reserved or c isn't valid
+// metadata.
+func validateMetadata(code Code, c Coder) error {
+	if code < MinUserCode {
+		return New(ErrReservedCode, "code %d is reserved, codes must be at least %d", code, MinUserCode)
+	}
+	if status := c.HTTPStatus(); status < 100 || status > 599 {
+		return New(ErrInvalidCode, "code %d has HTTP status %d, statuses must be between 100 and 599", code, status)
+	}
+	if coder, ok := c.(GRPCCoder); ok && coder.GRPCStatus() > GRPCUnauthenticated {
+		return New(ErrInvalidCode, "code %d has unknown gRPC status %d", code, coder.GRPCStatus())
+	}
+	return nil
+}
+
+// registerBuiltinCode registers one of the package's reserved codes,
+// bypassing the checks in RegisterCode.
+func registerBuiltinCode(code Code, c Coder) {
+	setCode(code, c)
+}
+
+// statusMessages contains default external error text keyed by HTTP
+// status.
+var statusMessages = map[int]string{}
+var statusMux = &sync.RWMutex{}
+
+// SetDefaultMessageForStatus sets the external error text used for codes
+// mapped to status that don't define their own. status may be a specific
+// status such as 503 or a class such as 500, which applies to any 5xx
+// status without a more specific default.
+func SetDefaultMessageForStatus(status int, msg string) {
+	statusMux.Lock()
+	statusMessages[status] = msg
+	statusMux.Unlock()
+}
+
+// defaultMessageForStatus returns the default external error text for
+// status, if any.
+func defaultMessageForStatus(status int) (string, bool) {
+	statusMux.RLock()
+	defer statusMux.RUnlock()
+	if msg, ok := statusMessages[status]; ok {
+		return msg, true
+	}
+	msg, ok := statusMessages[status-status%100]
+	return msg, ok
+}
 
 // ErrCode implements coder
 type ErrCode struct {
@@ -80,6 +361,14 @@ type ErrCode struct {
 	Int string
 	// HTTP status that should be used for the associated error code.
 	HTTP int
+	// gRPC status that should be used for the associated error code. If
+	// not set, the status is derived from HTTP.
+	GRPC GRPCCode
+	// Whether errors with the associated error code can be retried.
+	Retryable bool
+	// Severity that should be used for the associated error code. If not
+	// set, SeverityError is used.
+	Severity Severity
 }
 
 // Detail returns the internal error message, if any.
@@ -92,29 +381,85 @@ func (code ErrCode) String() string {
 	return code.Ext
 }
 
-// HTTPStatus returns the associated HTTP status code, if any. Otherwise, returns 200.
+// HTTPStatus returns the associated HTTP status code, if any. Otherwise, returns 500.
 func (code ErrCode) HTTPStatus() int {
 	if 0 == code.HTTP {
-		return 200
+		return http.StatusInternalServerError
 	}
 	return code.HTTP
 }
 
+// HasHTTPStatus reports whether an HTTP status was set for the code, so
+// an explicit 500 can be told apart from the default returned by
+// HTTPStatus.
+func (code ErrCode) HasHTTPStatus() bool {
+	return 0 != code.HTTP
+}
+
+// explicitHTTPStatus returns the HTTP status of c, reporting false if the
+// status wasn't set or is the generic 200. Coders that implement
+// HasHTTPStatus() bool can report an unset status, for other coders only
+// a 0 status is unset.
+func explicitHTTPStatus(c Coder) (int, bool) {
+	if c, ok := c.(interface{ HasHTTPStatus() bool }); ok && !c.HasHTTPStatus() {
+		return 0, false
+	}
+	status := c.HTTPStatus()
+	return status, 0 != status && http.StatusOK != status
+}
+
+// IsRetryable returns whether errors with the associated error code can be
+// retried.
+func (code ErrCode) IsRetryable() bool {
+	return code.Retryable
+}
+
 func init() {
+	// Built-in code ranges
+	RegisterRange(CodeRange{Min: ErrSuccess, Max: ErrSuccess, Name: "success"})
+	RegisterRange(CodeRange{Min: ErrUnknown, Max: 99, Name: "internal"})
+	RegisterRange(CodeRange{Min: 100, Max: 199, Name: "encoding"})
+
 	// Success
-	Codes[ErrSuccess] = ErrCode{"ok", "ok", 0}
+	registerBuiltinCode(ErrSuccess, ErrCode{Ext: "ok", Int: "ok", HTTP: http.StatusOK})
 
 	// Internal errors
-	Codes[ErrUnknown] = ErrCode{"an unknown error occurred", "", 0}
-	Codes[ErrFatal] = ErrCode{"a fatal error occurred", "a fatal error occurred", 0}
-	Codes[ErrCodeNotFound] = ErrCode{"code not found", "code not found", 0}
+	registerBuiltinCode(ErrUnknown, ErrCode{Ext: "an unknown error occurred"})
+	registerBuiltinCode(ErrFatal, ErrCode{Ext: "a fatal error occurred", Int: "a fatal error occurred", Severity: SeverityFatal})
+	registerBuiltinCode(ErrCodeNotFound, ErrCode{Ext: "code not found", Int: "code not found"})
+	registerBuiltinCode(ErrCodeExists, ErrCode{Ext: "code already registered", Int: "code already registered"})
+	registerBuiltinCode(ErrReservedCode, ErrCode{Ext: "code is reserved", Int: "code is reserved"})
+	registerBuiltinCode(ErrInvalidCode, ErrCode{Ext: "code is not valid", Int: "code metadata is not valid"})
+	registerBuiltinCode(ErrInvalidRange, ErrCode{Ext: "code range is not valid", Int: "code range is not valid"})
 
 	// Encoding errors
-	Codes[ErrDecodingJSON] = ErrCode{"JSON data could not be decoded", "JSON data could not be decoded", 0}
-	Codes[ErrDecodingToml] = ErrCode{"TOML data could not be decoded", "TOML data could not be decoded", 0}
-	Codes[ErrDecodingYaml] = ErrCode{"YAML data could not be decoded", "YAML data could not be decoded", 0}
-	Codes[ErrEncodingJSON] = ErrCode{"JSON data could not be encoded", "JSON data could not be encoded", 0}
-	Codes[ErrEncodingToml] = ErrCode{"TOML data could not be encoded", "TOML data could not be encoded", 0}
-	Codes[ErrEncodingYaml] = ErrCode{"YAML data could not be encoded", "YAML data could not be encoded", 0}
-	Codes[ErrTypeConversionFailed] = ErrCode{"data type conversion failed", "data type conversion failed", 0}
+	registerBuiltinCode(ErrDecodingJSON, ErrCode{Ext: "JSON data could not be decoded", Int: "JSON data could not be decoded"})
+	registerBuiltinCode(ErrDecodingToml, ErrCode{Ext: "TOML data could not be decoded", Int: "TOML data could not be decoded"})
+	registerBuiltinCode(ErrDecodingYaml, ErrCode{Ext: "YAML data could not be decoded", Int: "YAML data could not be decoded"})
+	registerBuiltinCode(ErrEncodingJSON, ErrCode{Ext: "JSON data could not be encoded", Int: "JSON data could not be encoded"})
+	registerBuiltinCode(ErrEncodingToml, ErrCode{Ext: "TOML data could not be encoded", Int: "TOML data could not be encoded"})
+	registerBuiltinCode(ErrEncodingYaml, ErrCode{Ext: "YAML data could not be encoded", Int: "YAML data could not be encoded"})
+	registerBuiltinCode(ErrTypeConversionFailed, ErrCode{Ext: "data type conversion failed", Int: "data type conversion failed"})
+
+	// Built-in code names
+	RegisterCodeName(ErrSuccess, "ErrSuccess")
+	RegisterCodeName(ErrUnknown, "ErrUnknown")
+	RegisterCodeName(ErrFatal, "ErrFatal")
+	RegisterCodeName(ErrCodeNotFound, "ErrCodeNotFound")
+	RegisterCodeName(ErrCodeExists, "ErrCodeExists")
+	RegisterCodeName(ErrReservedCode, "ErrReservedCode")
+	RegisterCodeName(ErrInvalidCode, "ErrInvalidCode")
+	RegisterCodeName(ErrInvalidRange, "ErrInvalidRange")
+	RegisterCodeName(ErrDecodingFailed, "ErrDecodingFailed")
+	RegisterCodeName(ErrDecodingJSON, "ErrDecodingJSON")
+	RegisterCodeName(ErrDecodingToml, "ErrDecodingToml")
+	RegisterCodeName(ErrDecodingYaml, "ErrDecodingYaml")
+	RegisterCodeName(ErrEncodingFailed, "ErrEncodingFailed")
+	RegisterCodeName(ErrEncodingJSON, "ErrEncodingJSON")
+	RegisterCodeName(ErrEncodingToml, "ErrEncodingToml")
+	RegisterCodeName(ErrEncodingYaml, "ErrEncodingYaml")
+	RegisterCodeName(ErrInvalidJSON, "ErrInvalidJSON")
+	RegisterCodeName(ErrInvalidToml, "ErrInvalidToml")
+	RegisterCodeName(ErrInvalidYaml, "ErrInvalidYaml")
+	RegisterCodeName(ErrTypeConversionFailed, "ErrTypeConversionFailed")
 }
@@ -0,0 +1,336 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestSetDefaultMessageForStatus(t *testing.T) {
+	SetDefaultMessageForStatus(500, "Internal server error")
+	defer delete(statusMessages, 500)
+
+	setTestCode(t, errTestCode, ErrCode{Int: "database unavailable", HTTP: 503})
+
+	err := New(errTestCode, "query failed")
+	if "Internal server error (code:9000)" != fmt.Sprintf("%v", err) {
+		t.Errorf("Expected 'Internal server error (code:9000)', received '%v'", err)
+	}
+
+	// A specific status takes precedence over its class
+	SetDefaultMessageForStatus(503, "Service unavailable")
+	defer delete(statusMessages, 503)
+	if "Service unavailable (code:9000)" != fmt.Sprintf("%v", err) {
+		t.Errorf("Expected 'Service unavailable (code:9000)', received '%v'", err)
+	}
+
+	// Codes with external text keep their own
+	setTestCode(t, errTestCode, ErrCode{Ext: "try again later", HTTP: 503})
+	if "try again later (code:9000)" != fmt.Sprintf("%v", err) {
+		t.Errorf("Expected 'try again later (code:9000)', received '%v'", err)
+	}
+}
+
+func TestRegisterCode(t *testing.T) {
+	cleanupCodes(t, errTestCode)
+
+	if err := RegisterCode(errTestCode, ErrCode{Ext: "test", HTTP: 400}); nil != err {
+		t.Fatalf("Expected nil, received %s", err)
+	}
+
+	// Registering identical metadata is allowed
+	if err := RegisterCode(errTestCode, ErrCode{Ext: "test", HTTP: 400}); nil != err {
+		t.Errorf("Expected nil, received %s", err)
+	}
+
+	// Registering different metadata is not
+	err := RegisterCode(errTestCode, ErrCode{Ext: "other", HTTP: 500})
+	if nil == err {
+		t.Fatalf("Expected an error registering a duplicate code")
+	}
+	if ErrCodeExists != err.(*Err).Code() {
+		t.Errorf("Expected %d, received %d", ErrCodeExists, err.(*Err).Code())
+	}
+	if c, _ := LookupCode(errTestCode); "test" != c.String() {
+		t.Errorf("Expected 'test', received '%s'", c.String())
+	}
+
+	func() {
+		defer func() {
+			if nil == recover() {
+				t.Errorf("Expected MustRegisterCode to panic")
+			}
+		}()
+		MustRegisterCode(errTestCode, ErrCode{Ext: "other"})
+	}()
+}
+
+func TestRegisterCodes(t *testing.T) {
+	setTestCode(t, errTestCode+1, ErrCode{Ext: "existing"})
+	cleanupCodes(t, errTestCode)
+
+	err := RegisterCodes(map[Code]Coder{
+		errTestCode:     ErrCode{Ext: "new"},
+		errTestCode + 1: ErrCode{Ext: "duplicate"},
+	})
+	if nil == err {
+		t.Fatalf("Expected an error registering a duplicate code")
+	}
+	if _, ok := LookupCode(errTestCode); ok {
+		t.Errorf("Expected no codes to be registered")
+	}
+
+	deleteCode(errTestCode + 1)
+	err = RegisterCodes(map[Code]Coder{
+		errTestCode:     ErrCode{Ext: "new"},
+		errTestCode + 1: ErrCode{Ext: "duplicate"},
+	})
+	if nil != err {
+		t.Fatalf("Expected nil, received %s", err)
+	}
+	if c, _ := LookupCode(errTestCode + 1); "duplicate" != c.String() {
+		t.Errorf("Expected 'duplicate', received '%s'", c.String())
+	}
+}
+
+func TestRegisterReservedCode(t *testing.T) {
+	err := RegisterCode(500, ErrCode{Ext: "reserved"})
+	if nil == err {
+		t.Fatalf("Expected an error registering a reserved code")
+	}
+	if ErrReservedCode != err.(*Err).Code() {
+		t.Errorf("Expected %d, received %d", ErrReservedCode, err.(*Err).Code())
+	}
+	if _, ok := LookupCode(500); ok {
+		t.Errorf("Expected code 500 to not be registered")
+	}
+	if err := RegisterCodes(map[Code]Coder{500: ErrCode{Ext: "reserved"}}); nil == err {
+		t.Errorf("Expected an error registering a reserved code")
+	}
+
+	if existing, ok := LookupCode(MinUserCode); ok {
+		deleteCode(MinUserCode)
+		t.Cleanup(func() { setCode(MinUserCode, existing) })
+	} else {
+		cleanupCodes(t, MinUserCode)
+	}
+	if err := RegisterCode(MinUserCode, ErrCode{Ext: "user"}); nil != err {
+		t.Errorf("Expected nil, received %s", err)
+	}
+}
+
+func TestRegisterCodeStatus(t *testing.T) {
+	cleanupCodes(t, errTestCode)
+
+	tests := []struct {
+		code  ErrCode
+		valid bool
+	}{
+		{ErrCode{Ext: "default"}, true},
+		{ErrCode{HTTP: 100}, true},
+		{ErrCode{HTTP: 404}, true},
+		{ErrCode{HTTP: 599}, true},
+		{ErrCode{HTTP: 42}, false},
+		{ErrCode{HTTP: 99}, false},
+		{ErrCode{HTTP: 600}, false},
+		{ErrCode{HTTP: 5000}, false},
+		{ErrCode{HTTP: -404}, false},
+		{ErrCode{GRPC: GRPCUnauthenticated}, true},
+		{ErrCode{GRPC: GRPCUnauthenticated + 1}, false},
+	}
+	for _, test := range tests {
+		deleteCode(errTestCode)
+		err := RegisterCode(errTestCode, test.code)
+		if test.valid && nil != err {
+			t.Errorf("Expected nil for %+v, received %s", test.code, err)
+		}
+		if !test.valid {
+			if nil == err {
+				t.Errorf("Expected an error for %+v", test.code)
+			} else if ErrInvalidCode != err.(*Err).Code() {
+				t.Errorf("Expected %d, received %d", ErrInvalidCode, err.(*Err).Code())
+			}
+			if _, ok := LookupCode(errTestCode); ok {
+				t.Errorf("Expected %+v to not be registered", test.code)
+			}
+		}
+	}
+
+	err := RegisterCodes(map[Code]Coder{
+		errTestCode:     ErrCode{HTTP: 404},
+		errTestCode + 1: ErrCode{HTTP: 5000},
+	})
+	if nil == err || "code 9001 has HTTP status 5000, statuses must be between 100 and 599" != err.(*Err).Msg() {
+		t.Errorf("Expected a descriptive error, received %v", err)
+	}
+}
+
+func TestSetCodeValidation(t *testing.T) {
+	cleanupCodes(t, errTestCode)
+
+	// Existing metadata is replaced
+	if err := SetCode(errTestCode, ErrCode{Ext: "first", HTTP: 400}); nil != err {
+		t.Fatalf("Expected nil, received %s", err)
+	}
+	if err := SetCode(errTestCode, ErrCode{Ext: "second", HTTP: 404}); nil != err {
+		t.Fatalf("Expected nil, received %s", err)
+	}
+	if c, _ := LookupCode(errTestCode); "second" != c.String() {
+		t.Errorf("Expected 'second', received '%s'", c.String())
+	}
+
+	// Invalid metadata isn't set
+	err := SetCode(errTestCode, ErrCode{Ext: "invalid", HTTP: 42})
+	if nil == err || ErrInvalidCode != err.(*Err).Code() {
+		t.Errorf("Expected code %d, received %v", ErrInvalidCode, err)
+	}
+	if c, _ := LookupCode(errTestCode); "second" != c.String() {
+		t.Errorf("Expected 'second', received '%s'", c.String())
+	}
+
+	// Reserved codes can't be replaced
+	err = SetCode(ErrFatal, ErrCode{Ext: "replaced"})
+	if nil == err || ErrReservedCode != err.(*Err).Code() {
+		t.Errorf("Expected code %d, received %v", ErrReservedCode, err)
+	}
+	if c, _ := LookupCode(ErrFatal); "a fatal error occurred" != c.String() {
+		t.Errorf("Expected 'a fatal error occurred', received '%s'", c.String())
+	}
+}
+
+func TestConcurrentCodes(t *testing.T) {
+	codes := make([]Code, 0, 100)
+	for a := 0; a < 100; a++ {
+		codes = append(codes, errTestCode+Code(a))
+	}
+	cleanupCodes(t, codes...)
+
+	err := New(errTestCode, "new")
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for a := 0; a < 100; a++ {
+			SetCode(errTestCode+Code(a), ErrCode{Ext: "test", HTTP: 400})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for a := 0; a < 100; a++ {
+			_ = fmt.Sprintf("%+v", err)
+			_ = err.HTTPStatus()
+		}
+	}()
+	wg.Wait()
+
+	if c, ok := LookupCode(errTestCode); !ok || "test" != c.String() {
+		t.Errorf("Expected code %d to be registered", errTestCode)
+	}
+}
+
+func TestCodeName(t *testing.T) {
+	defer func() {
+		codesMux.Lock()
+		delete(codeNames, errTestCode)
+		codesMux.Unlock()
+	}()
+
+	if "ErrInvalidJSON" != ErrInvalidJSON.Name() {
+		t.Errorf("Expected 'ErrInvalidJSON', received '%s'", ErrInvalidJSON.Name())
+	}
+	if "" != errTestCode.Name() {
+		t.Errorf("Expected '', received '%s'", errTestCode.Name())
+	}
+	RegisterCodeName(errTestCode, "ErrTest")
+	if "ErrTest" != errTestCode.Name() {
+		t.Errorf("Expected 'ErrTest', received '%s'", errTestCode.Name())
+	}
+}
+
+func TestAllCodes(t *testing.T) {
+	codes := AllCodes()
+	found := map[Code]CodeInfo{}
+	for k, info := range codes {
+		if k > 0 && codes[k-1].Code >= info.Code {
+			t.Errorf("Expected codes sorted by code, received %d before %d", codes[k-1].Code, info.Code)
+		}
+		found[info.Code] = info
+	}
+
+	tests := []CodeInfo{
+		{ErrSuccess, "ok", "ok", 200},
+		{ErrUnknown, "an unknown error occurred", "", 500},
+		{ErrFatal, "a fatal error occurred", "a fatal error occurred", 500},
+		{ErrCodeNotFound, "code not found", "code not found", 500},
+		{ErrCodeExists, "code already registered", "code already registered", 500},
+		{ErrReservedCode, "code is reserved", "code is reserved", 500},
+		{ErrInvalidCode, "code is not valid", "code metadata is not valid", 500},
+		{ErrInvalidRange, "code range is not valid", "code range is not valid", 500},
+		{ErrDecodingJSON, "JSON data could not be decoded", "JSON data could not be decoded", 500},
+		{ErrDecodingToml, "TOML data could not be decoded", "TOML data could not be decoded", 500},
+		{ErrDecodingYaml, "YAML data could not be decoded", "YAML data could not be decoded", 500},
+		{ErrEncodingJSON, "JSON data could not be encoded", "JSON data could not be encoded", 500},
+		{ErrEncodingToml, "TOML data could not be encoded", "TOML data could not be encoded", 500},
+		{ErrEncodingYaml, "YAML data could not be encoded", "YAML data could not be encoded", 500},
+		{ErrTypeConversionFailed, "data type conversion failed", "data type conversion failed", 500},
+	}
+	for _, test := range tests {
+		if info, ok := found[test.Code]; !ok {
+			t.Errorf("Expected code %d to be present", test.Code)
+		} else if test != info {
+			t.Errorf("Expected %+v, received %+v", test, info)
+		}
+	}
+}
+
+func TestHasCode(t *testing.T) {
+	if !ErrFatal.Is(ErrFatal) || ErrFatal.Is(ErrUnknown) || ErrFatal.Is(errors.New("fatal")) {
+		t.Errorf("Expected codes to match only themselves")
+	}
+	if !errors.Is(fmt.Errorf("failed: %w", ErrFatal), ErrFatal) {
+		t.Errorf("Expected errors.Is to match a wrapped code")
+	}
+
+	err := New(ErrDecodingJSON, "decode failed")
+	err = Wrap(err, ErrDecodingToml, "fallback failed")
+	err = Wrap(err, ErrFatal, "load failed")
+	tests := []struct {
+		err    error
+		code   Code
+		expect bool
+	}{
+		{err, ErrFatal, true},
+		{err, ErrDecodingToml, true},
+		{err, ErrDecodingJSON, true},
+		{err, ErrInvalidJSON, false},
+		{fmt.Errorf("request failed: %w", err), ErrDecodingJSON, true},
+		{Msg{code: ErrInvalidJSON}, ErrInvalidJSON, true},
+		{errors.New("plain"), ErrUnknown, false},
+		{nil, ErrSuccess, false},
+	}
+	for _, test := range tests {
+		if test.expect != HasCode(test.err, test.code) {
+			t.Errorf("Expected %t for code %d in '%v', received %t", test.expect, test.code, test.err, !test.expect)
+		}
+	}
+}
+
+func TestCodeOf(t *testing.T) {
+	var nilErr *Err
+	tests := []struct {
+		err    error
+		expect Code
+	}{
+		{nil, ErrSuccess},
+		{New(ErrDecodingJSON, "decode failed"), ErrDecodingJSON},
+		{Wrap(New(ErrDecodingJSON, "decode failed"), ErrFatal, "load failed"), ErrFatal},
+		{nilErr, ErrUnknown},
+		{errors.New("plain"), ErrUnknown},
+	}
+	for _, test := range tests {
+		if code := CodeOf(test.err); test.expect != code {
+			t.Errorf("Expected %d for '%v', received %d", test.expect, test.err, code)
+		}
+	}
+}
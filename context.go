@@ -0,0 +1,21 @@
+package errors
+
+import (
+	"context"
+)
+
+// ctxKey is the context key for an error stack.
+type ctxKey struct{}
+
+// ContextWithErr returns a copy of ctx that carries err. The stack is
+// stored by reference, so frames added to err later with With, Wrap or
+// Push are visible through the returned context.
+func ContextWithErr(ctx context.Context, err *Err) context.Context {
+	return context.WithValue(ctx, ctxKey{}, err)
+}
+
+// ErrFromContext returns the error stack carried by ctx, if any.
+func ErrFromContext(ctx context.Context) (*Err, bool) {
+	err, ok := ctx.Value(ctxKey{}).(*Err)
+	return err, ok && nil != err
+}
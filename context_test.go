@@ -0,0 +1,49 @@
+package errors
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestErrFromContext(t *testing.T) {
+	if _, ok := ErrFromContext(context.Background()); ok {
+		t.Errorf("Expected no error in an empty context")
+	}
+
+	ctx := ContextWithErr(context.Background(), New(ErrFatal, "request failed"))
+	err, ok := ErrFromContext(ctx)
+	if !ok {
+		t.Fatalf("Expected an error in the context")
+	}
+	err.With(errors.New("validation failed"), "invalid input")
+
+	// The update is visible through the same context value
+	err, ok = ErrFromContext(ctx)
+	if !ok {
+		t.Fatalf("Expected an error in the context")
+	}
+	if 2 != err.Len() {
+		t.Errorf("Expected 2, received %d", err.Len())
+	}
+	if ErrFatal != err.Code() {
+		t.Errorf("Expected %d, received %d", ErrFatal, err.Code())
+	}
+
+	// Concurrent updates are safe
+	var wg sync.WaitGroup
+	for a := 0; a < 10; a++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err, ok := ErrFromContext(ctx); ok {
+				err.With(errors.New("concurrent"), "concurrent")
+			}
+		}()
+	}
+	wg.Wait()
+	if 12 != err.Len() {
+		t.Errorf("Expected 12, received %d", err.Len())
+	}
+}
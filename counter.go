@@ -0,0 +1,46 @@
+package errors
+
+import (
+	"sync"
+)
+
+// Counter counts errors by their leading code, for aggregated reporting
+// such as the number of errors of each kind over a window. The zero value
+// is ready to use and a Counter is safe for concurrent use.
+type Counter struct {
+	mux    sync.Mutex
+	counts map[Code]int
+}
+
+// Record increments the count for the leading code of err, as returned by
+// CodeOf. nil errors aren't counted.
+func (counter *Counter) Record(err error) {
+	if nil == err {
+		return
+	}
+	code := CodeOf(err)
+	counter.mux.Lock()
+	if nil == counter.counts {
+		counter.counts = map[Code]int{}
+	}
+	counter.counts[code]++
+	counter.mux.Unlock()
+}
+
+// Reset clears all counts.
+func (counter *Counter) Reset() {
+	counter.mux.Lock()
+	counter.counts = nil
+	counter.mux.Unlock()
+}
+
+// Snapshot returns a copy of the counts recorded for each code.
+func (counter *Counter) Snapshot() map[Code]int {
+	counter.mux.Lock()
+	defer counter.mux.Unlock()
+	counts := make(map[Code]int, len(counter.counts))
+	for code, count := range counter.counts {
+		counts[code] = count
+	}
+	return counts
+}
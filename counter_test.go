@@ -0,0 +1,42 @@
+package errors
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	var counter Counter
+	var wg sync.WaitGroup
+	for a := 0; a < 10; a++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			counter.Record(New(ErrFatal, "fatal"))
+			counter.Record(Wrap(errors.New("eof"), ErrDecodingJSON, "decode"))
+			counter.Record(errors.New("plain"))
+			counter.Record(nil)
+		}()
+	}
+	wg.Wait()
+	counter.Record(New(ErrFatal, "fatal"))
+
+	expect := map[Code]int{ErrFatal: 11, ErrDecodingJSON: 10, ErrUnknown: 10}
+	snapshot := counter.Snapshot()
+	if !reflect.DeepEqual(expect, snapshot) {
+		t.Errorf("Expected %v, received %v", expect, snapshot)
+	}
+
+	// Snapshots are copies
+	snapshot[ErrFatal] = 0
+	if 11 != counter.Snapshot()[ErrFatal] {
+		t.Errorf("Expected 11, received %d", counter.Snapshot()[ErrFatal])
+	}
+
+	counter.Reset()
+	if 0 != len(counter.Snapshot()) {
+		t.Errorf("Expected no counts, received %v", counter.Snapshot())
+	}
+}
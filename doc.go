@@ -120,7 +120,7 @@ the stack behind the leading error:
 	)
 
 	func doSteps() error {
-		var errStack errs.Err
+		errStack := &errs.Err{}
 
 		err := doStep1()
 		if nil != err {
@@ -138,6 +138,9 @@ the stack behind the leading error:
 			errStack.With(err, "step 3 failed")
 		}
 
+		if 0 == errStack.Len() {
+			return nil
+		}
 		return errStack
 	}
 
@@ -145,12 +148,12 @@ Root cause of an error stack
 
 Retrieving the root cause of an error stack is straightforward:
 
-	log.Println(err.(errs.Stack).Cause())
+	log.Println(err.(*errs.Err).Cause())
 
 Similar to `pkg/errors`, you can easily switch on the type of any error
 in the stack (including the causer):
 
-	switch err.(errs.Err).Cause().(type) {
+	switch err.(*errs.Err).Cause().(type) {
 	case *MyError:
 			// handle specifically
 	default:
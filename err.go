@@ -2,34 +2,334 @@ package errors
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
-	"path"
-	"runtime"
-	"strings"
+	"reflect"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 )
 
-// Err defines an error heap.
+// Err defines an error heap. A nil *Err is treated as an empty stack by
+// the read-only accessors such as Len, Code, Error, Caller, HTTPStatus and
+// Format, so they don't panic.
 type Err struct {
 	errs []ErrMsg
 	mux  *sync.Mutex
+	once sync.Once
+	// Number of errors combined by Join or Append and the message of the
+	// first one, if any.
+	joined int
+	first  string
+	// Number of frames dropped to keep the stack within MaxStackDepth.
+	elided int
 }
 
-// New returns an error with caller information for debugging.
+// maxStackDepth is the maximum number of frames kept in an error stack,
+// or 0 if the stack size is not limited.
+var maxStackDepth int32
+
+// SetMaxStackDepth sets the maximum number of frames kept in an error
+// stack. When more frames are added, the oldest frames after the root cause
+// are dropped, so the root cause and the most recent context are kept, and
+// formatted traces note how many frames were elided. A depth of 1 is
+// treated as 2. If depth is 0 or negative, the stack size is not limited,
+// which is the default. The limit applies to frames added after it is set.
+func SetMaxStackDepth(depth int) {
+	if depth < 0 {
+		depth = 0
+	} else if 1 == depth {
+		depth = 2
+	} else if depth > math.MaxInt32 {
+		depth = math.MaxInt32
+	}
+	atomic.StoreInt32(&maxStackDepth, int32(depth))
+}
+
+// MaxStackDepth returns the maximum number of frames kept in an error
+// stack, or 0 if the stack size is not limited.
+func MaxStackDepth() int {
+	return int(atomic.LoadInt32(&maxStackDepth))
+}
+
+// traceSeparator is written between frames by the single-line %-v format.
+var traceSeparator = " "
+var traceSeparatorMux = &sync.RWMutex{}
+
+// SetTraceSeparator sets the separator written between frames by the
+// single-line %-v format, such as " | " for systems that split traces on a
+// delimiter. Separators are only written between frames, never before the
+// first or after the last. Passing an empty string restores the default
+// single space.
+func SetTraceSeparator(sep string) {
+	if "" == sep {
+		sep = " "
+	}
+	traceSeparatorMux.Lock()
+	traceSeparator = sep
+	traceSeparatorMux.Unlock()
+}
+
+// New returns an error with caller information for debugging. If data is
+// provided msg is used as a format string, otherwise msg is used as-is.
 func New(code Code, msg string, data ...interface{}) *Err {
+	str, e := formatMsg(msg, data)
+	return newErr(0, code, e, str)
+}
+
+// Newf returns an error with caller information for debugging, using
+// format as a format string even when no arguments are provided.
+func Newf(code Code, format string, args ...interface{}) *Err {
+	e := fmt.Errorf(format, args...)
+	return newErr(0, code, e, e.Error())
+}
+
+// NewBare returns an error without caller or call stack information, for
+// use where the cost of capturing them isn't wanted. If data is provided
+// msg is used as a format string, otherwise msg is used as-is.
+func NewBare(code Code, msg string, data ...interface{}) *Err {
+	str, e := formatMsg(msg, data)
+	return newBare(code, e, str)
+}
+
+// newBare returns a new error stack containing a single error without
+// caller information.
+func newBare(code Code, err error, msg string) *Err {
 	return &Err{
 		errs: []ErrMsg{Msg{
-			err:    fmt.Errorf(msg, data...),
-			caller: getCaller(),
+			err:    err,
+			caller: Call{},
+			code:   code,
+			msg:    msg,
+		}},
+		mux: &sync.Mutex{},
+	}
+}
+
+// NewIf is like New but returns nil unless cond is true, for validation:
+//
+//	if err := errors.NewIf("" == name, CodeInvalid, "name required"); nil != err {
+//		return err
+//	}
+//
+// The result is an untyped nil error rather than a nil *Err, so it compares
+// equal to nil.
+func NewIf(cond bool, code Code, msg string, data ...interface{}) error {
+	if !cond {
+		return nil
+	}
+	str, e := formatMsg(msg, data)
+	return newErr(0, code, e, str)
+}
+
+// NewSkip is like New but skips an additional skip caller frames when
+// capturing caller information, like runtime.Caller. This allows helper
+// functions that create errors to report their own caller instead.
+func NewSkip(skip int, code Code, msg string, data ...interface{}) *Err {
+	str, e := formatMsg(msg, data)
+	return newErr(skip, code, e, str)
+}
+
+// newErr returns a new error stack containing a single error. skip is the
+// number of additional caller frames to skip.
+func newErr(skip int, code Code, err error, msg string) *Err {
+	e := traceMsg(Msg{
+		err:    err,
+		caller: getCallerSkip(skip),
+		code:   code,
+		msg:    msg,
+	})
+	return &Err{
+		errs: []ErrMsg{e},
+		mux:  &sync.Mutex{},
+	}
+}
+
+/*
+RecoverInto recovers a panic and assigns it to err as an *Err with code,
+the call stack of the panic and the location of the panic as its caller.
+It must be deferred directly, usually with a named return value:
+
+	func parse(data []byte) (err error) {
+		defer errors.RecoverInto(&err, ErrFatal)
+		...
+	}
+
+If there is no panic, err is left unchanged.
+*/
+func RecoverInto(err *error, code Code) {
+	if v := recover(); nil != v {
+		*err = recoverErr(v, code)
+	}
+}
+
+// recoverErr converts a value recovered from a panic into an error stack
+// with code. The call stack is always captured, and the caller is the
+// location of the panic.
+func recoverErr(v interface{}, code Code) *Err {
+	e, ok := v.(error)
+	if !ok {
+		e = fmt.Errorf("%v", v)
+	}
+	trace := getTrace()
+	return &Err{
+		errs: []ErrMsg{Msg{
+			err:    e,
+			caller: panicCaller(trace),
 			code:   code,
-			msg:    fmt.Sprintf(msg, data...),
-			trace:  getTrace(),
+			msg:    fmt.Sprintf("panic: %v", v),
+			trace:  trace,
 		}},
 		mux: &sync.Mutex{},
 	}
 }
 
+// Annotate adds text to the message of the most recent error in the stack,
+// separated by a space, without adding a frame. The code, caller and
+// underlying error of the frame are kept. If args are provided format is
+// used as a format string, otherwise format is used as-is.
+func (err *Err) Annotate(format string, args ...interface{}) *Err {
+	note, _ := formatMsg(format, args)
+	return err.updateLast(func(msg Msg) Msg {
+		msg.msg += " " + note
+		if nil != msg.err {
+			msg.err = fmt.Errorf("%w %s", msg.err, note)
+		}
+		return msg
+	})
+}
+
+/*
+Append adds e to the stack as an independent error rather than wrapping
+it and returns the receiver. e is added as a frame, or as its frames if it
+is an *Err, so codes, callers and traces are preserved and errors.Is and
+errors.As match any of the appended errors. Errors that aren't an *Err or
+a Msg are added with the ErrUnknown code. The most recent error leads the
+stack.
+
+Append may be called on a nil *Err, in which case a new stack is
+allocated, so errors can be accumulated in a loop:
+
+	var errs *errors.Err
+	for _, item := range items {
+		errs = errs.Append(process(item))
+	}
+	return errs.ErrOrNil()
+
+If e is nil, or an empty *Err, the receiver is returned unchanged, so the
+result is a nil *Err if nothing was appended. A nil *Err stored in an error
+is not equal to nil, so return the result with ErrOrNil rather than
+directly from a function returning error. Once more than one error has been
+appended, Error returns the number of errors and the message of the first
+one until another frame is pushed.
+*/
+func (err *Err) Append(e error) *Err {
+	var frames []ErrMsg
+	if nil == e {
+		return err
+	} else if msgs, ok := e.(*Err); ok {
+		frames = msgs.stack()
+	} else if msg, ok := e.(Msg); ok {
+		frames = []ErrMsg{msg}
+	} else {
+		frames = []ErrMsg{Msg{
+			err:    e,
+			caller: getCaller(),
+			code:   ErrUnknown,
+			msg:    e.Error(),
+		}}
+	}
+	if 0 == len(frames) {
+		return err
+	}
+
+	if nil == err {
+		err = &Err{
+			errs: []ErrMsg{},
+			mux:  &sync.Mutex{},
+		}
+	}
+	err.Lock()
+	defer err.Unlock()
+	if 0 == err.joined {
+		if k := len(err.errs) - 1; k >= 0 {
+			err.joined, err.first = 1, err.errs[k].Error()
+		} else if msgs, ok := e.(*Err); ok {
+			// Keep the internal text, redaction is applied by Error
+			err.first = msgs.text()
+		} else {
+			err.first = e.Error()
+		}
+	}
+	err.errs = append(err.errs, frames...)
+	err.joined++
+	err.limit()
+	return err
+}
+
+/*
+As implements the interface used by errors.As. Frames are checked from
+the most recent to the root cause:
+
+  - A *Msg target receives the most recent Msg frame.
+  - An *ErrMsg target receives the most recent frame.
+  - A *Code target receives the leading error code.
+
+Any other target is matched against the underlying error of each frame
+using errors.As, which allows typed errors from other packages to be
+extracted from a stack.
+*/
+func (err *Err) As(target interface{}) bool {
+	errs := err.stack()
+	switch t := target.(type) {
+	case *Msg:
+		for k := len(errs) - 1; k >= 0; k-- {
+			if msg, ok := errs[k].(Msg); ok {
+				*t = msg
+				return true
+			}
+		}
+	case *ErrMsg:
+		if err.Len() > 0 {
+			*t = err.Last()
+			return true
+		}
+	case *Code:
+		if err.Len() > 0 {
+			*t = err.Code()
+			return true
+		}
+	}
+
+	for k := len(errs) - 1; k >= 0; k-- {
+		if unwrapAs(errs[k], target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Breadcrumbs returns a compact trail of the stack, one entry per frame
+// ordered from the root cause to the most recent error. Frames that
+// implement Operation() string contribute their operation name, all
+// others contribute their message.
+func (err *Err) Breadcrumbs() []string {
+	errs := err.stack()
+	crumbs := make([]string, 0, len(errs))
+	for _, msg := range errs {
+		crumb := msg.Msg()
+		if op, ok := msg.(interface{ Operation() string }); ok && "" != op.Operation() {
+			crumb = op.Operation()
+		}
+		crumbs = append(crumbs, crumb)
+	}
+	return crumbs
+}
+
 // Caller returns the most recent error caller.
 func (err *Err) Caller() Caller {
 	var caller Caller
@@ -41,12 +341,46 @@ func (err *Err) Caller() Caller {
 
 // Cause returns the root cause of an error stack.
 func (err *Err) Cause() error {
-	if err.Len() > 0 {
+	err.Lock()
+	defer err.Unlock()
+	if len(err.errs) > 0 {
 		return err.errs[0]
 	}
 	return nil
 }
 
+// Children returns the stacks of the sibling errors wrapped by WrapAll,
+// taken from the most recent frame that has any. Changes to the returned
+// slice don't affect err.
+func (err *Err) Children() []*Err {
+	errs := err.stack()
+	for k := len(errs) - 1; k >= 0; k-- {
+		if msg, ok := errs[k].(Msg); ok && len(msg.children) > 0 {
+			return append([]*Err(nil), msg.children...)
+		}
+	}
+	return nil
+}
+
+/*
+Clone returns a copy of the error stack with its own mutex. Annotate,
+Append, Push, SetCode, With, WithCaller, WithField, WithFields,
+WithHTTPStatus, WithRetryable, WithSeverity, WithStack, WithTemporary and
+WithTimeout all modify the error they are called on, so clone an error that
+may be referenced elsewhere before modifying it.
+*/
+func (err *Err) Clone() *Err {
+	err.Lock()
+	defer err.Unlock()
+	return &Err{
+		errs:   append([]ErrMsg(nil), err.errs...),
+		mux:    &sync.Mutex{},
+		joined: err.joined,
+		first:  err.first,
+		elided: err.elided,
+	}
+}
+
 // Code returns the most recent error code.
 func (err *Err) Code() Code {
 	code := ErrUnknown
@@ -56,159 +390,632 @@ func (err *Err) Code() Code {
 	return code
 }
 
+// Dedup returns a copy of the error stack with consecutive frames that
+// share the same file, line and code collapsed into a single frame, such as
+// frames from recursive or retried code. Formatted traces annotate a
+// collapsed frame with its repeat count, like "×3". The frame closest to
+// the root cause is kept.
+func (err *Err) Dedup() *Err {
+	errs, elided := err.stackElided()
+	dedup := &Err{
+		errs:   []ErrMsg{},
+		mux:    &sync.Mutex{},
+		elided: elided,
+	}
+	for _, msg := range errs {
+		if k := len(dedup.errs) - 1; k >= 0 && sameFrame(dedup.errs[k], msg) {
+			if last, ok := dedup.errs[k].(Msg); ok {
+				last.repeat = frameRepeat(last) + frameRepeat(msg)
+				dedup.errs[k] = last
+				continue
+			}
+		}
+		dedup.errs = append(dedup.errs, msg)
+	}
+	return dedup
+}
+
 // Detail implements the Coder interface. Detail returns the single-line stack trace.
+// Detail is internal error text, so the redactor isn't applied.
 func (err *Err) Detail() string {
 	if err.Len() > 0 {
-		if code, ok := Codes[err.Code()]; ok {
+		if code, ok := LookupCode(err.Code()); ok {
 			if "" != code.Detail() {
 				return code.Detail()
 			}
-			return err.Error()
+			return err.text()
 		}
 	}
 	return ""
 }
 
-// Error implements the error interface.
-func (err Err) Error() string {
+// Equal reports whether a and b are logically equal. Two *Err values are
+// equal when their stacks have the same length and each pair of frames
+// has the same code, message and fields. Callers are ignored. Other
+// errors are equal when their error text matches.
+func Equal(a, b error) bool {
+	return equal(a, b, false)
+}
+
+// EqualCallers is like Equal but also requires each pair of frames to
+// have the same caller file and line.
+func EqualCallers(a, b error) bool {
+	return equal(a, b, true)
+}
+
+func equal(a, b error, callers bool) bool {
+	if nil == a || nil == b {
+		return a == b
+	}
+
+	errA, okA := a.(*Err)
+	errB, okB := b.(*Err)
+	if !okA || !okB {
+		return !okA && !okB && a.Error() == b.Error()
+	}
+
+	stackA, stackB := errA.stack(), errB.stack()
+	if len(stackA) != len(stackB) {
+		return false
+	}
+	for k := range stackA {
+		msgA, msgB := stackA[k], stackB[k]
+		if msgA.Code() != msgB.Code() ||
+			msgA.Msg() != msgB.Msg() ||
+			msgA.Error() != msgB.Error() ||
+			!reflect.DeepEqual(frameFields(msgA), frameFields(msgB)) {
+			return false
+		}
+		if callers && !equalCallers(msgA.Caller(), msgB.Caller()) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalCallers(a, b Caller) bool {
+	if nil == a || nil == b {
+		return a == b
+	}
+	return a.File() == b.File() && a.Line() == b.Line()
+}
+
+// ErrOrNil returns err as an error, or an untyped nil error if err is nil or
+// its stack is empty, so the result compares equal to nil when there is no
+// error.
+func (err *Err) ErrOrNil() error {
+	if 0 == err.Len() {
+		return nil
+	}
+	return err
+}
+
+// Error implements the error interface. Error has a pointer receiver, so
+// only *Err implements error; an Err value must be passed by address, such
+// as the *Err returned by New and Wrap.
+func (err *Err) Error() string {
+	if nil == err {
+		return ""
+	}
+	return redact(err.text())
+}
+
+// text returns the error text of the leading error without redaction.
+func (err *Err) text() string {
+	err.Lock()
+	defer err.Unlock()
 	str := ""
-	if err.Len() > 0 {
-		str = err.Last().Error()
+	if err.joined > 1 {
+		str = fmt.Sprintf("%d errors occurred: %s", err.joined, err.first)
+	} else if len(err.errs) > 0 {
+		str = err.errs[len(err.errs)-1].Error()
 	}
 	return str
 }
 
+// ErrorStack returns the multi-line detailed stack trace, the same as
+// formatting err with %+v.
+func (err *Err) ErrorStack() string {
+	return fmt.Sprintf("%+v", err)
+}
+
+// ErrorTrace returns the condensed stack trace with one line per frame,
+// the same as formatting err with %#v.
+func (err *Err) ErrorTrace() string {
+	return fmt.Sprintf("%#v", err)
+}
+
+// Fields returns the structured metadata attached to the error stack.
+// Fields from every frame are merged, with more recent frames taking
+// precedence. If the underlying error of a frame wraps another *Err, such
+// as an *Err wrapped with fmt.Errorf and %w, its fields are merged beneath
+// the fields of that frame.
+func (err *Err) Fields() map[string]interface{} {
+	fields := map[string]interface{}{}
+	for _, msg := range err.stack() {
+		var inner *Err
+		if unwrapAs(msg, &inner) && err != inner {
+			for k, v := range inner.Fields() {
+				fields[k] = v
+			}
+		}
+		for k, v := range frameFields(msg) {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// FirstCode returns the most recent frame carrying any of codes, if any.
+func (err *Err) FirstCode(codes ...Code) (ErrMsg, bool) {
+	errs := err.stack()
+	for k := len(errs) - 1; k >= 0; k-- {
+		for _, code := range codes {
+			if code == errs[k].Code() {
+				return errs[k], true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Flatten returns a copy of the error stack ordered from the root cause to
+// the most recent error, with duplicate frames removed. Frames are
+// duplicates if they share the same caller, code and message, in which
+// case the frame closest to the root cause is kept.
+func (err *Err) Flatten() *Err {
+	type key struct {
+		caller string
+		code   Code
+		msg    string
+	}
+
+	seen := map[key]bool{}
+	flat := &Err{mux: &sync.Mutex{}}
+	for _, msg := range err.stack() {
+		k := key{callerText(msg.Caller()), msg.Code(), msg.Msg()}
+		if !seen[k] {
+			seen[k] = true
+			flat.errs = append(flat.errs, msg)
+		}
+	}
+	return flat
+}
+
 /*
 Format implements fmt.Formatter. https://golang.org/pkg/fmt/#hdr-Printing
 
 Format formats the stack trace output. Several verbs are supported:
+
 	%s  - Returns the user-safe error string mapped to the error code or
 	    the error message if none is specified.
 
+	%q  - Returns the %s error string as a double-quoted Go string
+	    literal, safe for embedding. %+q escapes non-ASCII characters.
+
+	Width and precision are supported for %s and %v, so %20s pads the
+	message on the left and %.10s truncates it. The '-' flag pads on the
+	right only for %s: %-20s pads the message, while %-20v selects the
+	single-line stack trace described below and ignores the width.
+
 	%v  - Alias for %s
 
-	%#v - Returns the full stack trace in a single line, useful for
-		logging. Same as %#v with the newlines escaped.
+	%#v - Returns a multi-line condensed stack trace, one line per
+	    error. Note that unlike most types, %#v doesn't produce a Go
+	    syntax representation of the error.
 
-	%-v - Returns a multi-line stack trace, one column-delimited line
-	    per error.
+	%-v - Returns the condensed stack trace in a single line, useful for
+	    logging. Frames are separated by a space, or the separator set
+	    with SetTraceSeparator.
 
 	%+v - Returns a multi-line detailed stack trace with multiple lines
 	      per error. Only useful for human consumption.
+
+	%+#v - Returns the JSON representation of the error stack, the same
+	    as JSON().
 */
 func (err *Err) Format(state fmt.State, verb rune) {
+	var flag rune
+	if 'q' == verb && state.Flag('+') {
+		flag = '+'
+	} else if 'v' == verb {
+		switch {
+		case state.Flag('+') && state.Flag('#'):
+			flag = 'j'
+		case state.Flag('+'):
+			flag = '+'
+		case state.Flag('#'):
+			flag = '#'
+		case state.Flag('-'):
+			flag = '-'
+		}
+	}
+	_, hasWidth := state.Width()
+	_, hasPrecision := state.Precision()
+	if (hasWidth || hasPrecision) && ('s' == verb || ('v' == verb && 0 == flag)) {
+		// Pad or truncate the externally-safe error message
+		var buf bytes.Buffer
+		err.fprint(&buf, verb, flag)
+		fmt.Fprintf(state, stringSpec(state), buf.String())
+		return
+	}
+	err.fprint(state, verb, flag)
+}
+
+// stringSpec returns a %s format specification with the width, precision
+// and '-' flag of state.
+func stringSpec(state fmt.State) string {
+	spec := "%"
+	if state.Flag('-') {
+		spec += "-"
+	}
+	if width, ok := state.Width(); ok {
+		spec += strconv.Itoa(width)
+	}
+	if precision, ok := state.Precision(); ok {
+		spec += "." + strconv.Itoa(precision)
+	}
+	return spec + "s"
+}
+
+/*
+Fprint writes err to w using verb, which is one of the formats supported
+by Format such as "%+v". The output is identical to
+fmt.Fprintf(w, verb, err), but stack traces are streamed to w frame by
+frame instead of being built in memory first. Other verbs are passed to
+fmt.Fprintf. The first error returned by w is returned.
+*/
+func (err *Err) Fprint(w io.Writer, verb string) error {
 	switch verb {
-	case 'v':
-		str := bytes.NewBuffer([]byte{})
-		for k := len(err.errs) - 1; k >= 0; k-- {
-			err := err.errs[k]
-			code, ok := Codes[err.Code()]
-			if !ok {
-				code = ErrCode{
-					Int: err.Error(),
-					Ext: err.Error(),
+	case "%v":
+		return err.fprint(w, 'v', 0)
+	case "%+v":
+		return err.fprint(w, 'v', '+')
+	case "%#v":
+		return err.fprint(w, 'v', '#')
+	case "%-v":
+		return err.fprint(w, 'v', '-')
+	case "%+#v", "%#+v":
+		return err.fprint(w, 'v', 'j')
+	case "%s":
+		return err.fprint(w, 's', 0)
+	case "%q":
+		return err.fprint(w, 'q', 0)
+	case "%+q":
+		return err.fprint(w, 'q', '+')
+	}
+	_, e := fmt.Fprintf(w, verb, err)
+	return e
+}
+
+// traceWriter writes formatted output, keeping the first write error and
+// skipping any further writes.
+type traceWriter struct {
+	w   io.Writer
+	err error
+}
+
+// printf writes formatted output unless a previous write failed.
+func (tw *traceWriter) printf(format string, args ...interface{}) {
+	if nil == tw.err {
+		_, tw.err = fmt.Fprintf(tw.w, format, args...)
+	}
+}
+
+// fprint writes err to w for verb, using flag to select the stack trace
+// format for the 'v' verb. Frames are separated rather than terminated so
+// the output has no trailing whitespace.
+func (err *Err) fprint(w io.Writer, verb, flag rune) error {
+	tw := &traceWriter{w: w}
+	if 'q' == verb {
+		// Quoted externally-safe error message
+		if '+' == flag {
+			tw.printf("%s", strconv.QuoteToASCII(err.Error()))
+		} else {
+			tw.printf("%s", strconv.Quote(err.Error()))
+		}
+		return tw.err
+	}
+	if 'v' != verb {
+		// Externally-safe error message
+		tw.printf("%s", err.Error())
+		return tw.err
+	}
+
+	if 'j' == flag {
+		// JSON representation
+		data, e := err.JSON()
+		if nil != e {
+			return e
+		}
+		_, e = w.Write(data)
+		return e
+	}
+
+	sep := "\n"
+	if '-' == flag {
+		traceSeparatorMux.RLock()
+		sep = traceSeparator
+		traceSeparatorMux.RUnlock()
+	}
+	errs, elided := err.stackElided()
+	for k := len(errs) - 1; k >= 0; k-- {
+		if len(errs)-1 != k {
+			tw.printf("%s", sep)
+		}
+		if 0 == k && elided > 0 && 0 != flag {
+			// Frames dropped to stay within MaxStackDepth
+			tw.printf("… %d frames elided …%s", elided, sep)
+		}
+		err := errs[k]
+		detail, message := frameText(err)
+		errMsgInt := fmt.Sprintf("%s (code:%d)", detail, err.Code())
+		errMsgExt := fmt.Sprintf("%s (code:%d)", message, err.Code())
+		repeat := ""
+		if n := frameRepeat(err); n > 1 {
+			repeat = fmt.Sprintf(" ×%d", n)
+		}
+
+		switch flag {
+		case '+':
+			// Extended stack trace
+			tw.printf("#%d: `%s`%s\n", k, callerFunc(err.Caller()), repeat)
+			tw.printf("\terror:   %s\n", err.Msg())
+			tw.printf("\tline:    %s\n", callerLine(err.Caller()))
+			tw.printf("\tdetail:  %s\n", errMsgInt)
+			tw.printf("\tmessage: %s", errMsgExt)
+			if fields := frameFields(err); len(fields) > 0 {
+				tw.printf("\n\tfields:")
+				for _, key := range sortedKeys(fields) {
+					tw.printf("\n\t\t%s: %v", key, fields[key])
 				}
 			}
 
-			errMsgInt := fmt.Sprintf("%d", err.Code())
-			if "" != code.Detail() {
-				errMsgInt = fmt.Sprintf("%s (code:%s)", code.Detail(), errMsgInt)
-			} else {
-				errMsgInt = fmt.Sprintf("%s (code:%s)", err.Error(), errMsgInt)
-			}
+		case '#', '-':
+			// Condensed or inline stack trace
+			tw.printf("#%d - caller: \"%s\" error: \"%s\" detail: \"%s\"%s",
+				k,
+				callerText(err.Caller()),
+				err.Msg(),
+				errMsgInt,
+				repeat,
+			)
 
-			errMsgExt := fmt.Sprintf("%d", err.Code())
-			if "" != code.String() {
-				errMsgExt = fmt.Sprintf("%s (code:%s)", code.String(), errMsgExt)
-			} else {
-				errMsgExt = fmt.Sprintf("%s (code:%s)", err.Error(), errMsgExt)
-			}
+		default:
+			// Externally-safe error message
+			tw.printf("%s", redact(errMsgExt))
+			return tw.err
+		}
+	}
+	return tw.err
+}
 
-			switch {
-			case state.Flag('+'):
-				// Extended stack trace
-				fmt.Fprintf(str, "#%d: `%s`\n", k, runtime.FuncForPC(err.Caller().Pc()).Name())
-				fmt.Fprintf(str, "\terror:   %s\n", err.Msg())
-				fmt.Fprintf(str, "\tline:    %s:%d\n", path.Base(err.Caller().File()), err.Caller().Line())
-				fmt.Fprintf(str, "\tdetail:  %s\n", errMsgInt)
-				fmt.Fprintf(str, "\tmessage: %s\n", errMsgExt)
-
-			case state.Flag('#'):
-				// Condensed stack trace
-				fmt.Fprintf(str, "#%d - caller: \"%s:%d:%s\" error: \"%s\" detail: \"%s\"\n",
-					k,
-					path.Base(err.Caller().File()),
-					err.Caller().Line(),
-					runtime.FuncForPC(err.Caller().Pc()).Name(),
-					err.Msg(),
-					errMsgInt,
-				)
-
-			case state.Flag('-'):
-				// Inline stack trace
-				fmt.Fprintf(str, "#%d - caller: \"%s:%d:%s\" error: \"%s\" detail: \"%s\" ",
-					//fmt.Fprintf(str, "#%d - \"%s\" %s:%d `%s` `%s` ",
-					k,
-					path.Base(err.Caller().File()),
-					err.Caller().Line(),
-					runtime.FuncForPC(err.Caller().Pc()).Name(),
-					err.Msg(),
-					errMsgInt,
-				)
-
-			default:
-				// Externally-safe error message
-				fmt.Fprintf(state, errMsgExt)
-				return
-			}
+// frameFields returns the structured metadata attached to a single frame,
+// if any.
+func frameFields(msg ErrMsg) map[string]interface{} {
+	if msg, ok := msg.(interface{ Fields() map[string]interface{} }); ok {
+		return msg.Fields()
+	}
+	return nil
+}
+
+// frameRepeat returns the number of consecutive frames a frame represents
+// after Dedup.
+func frameRepeat(msg ErrMsg) int {
+	if m, ok := msg.(Msg); ok && m.repeat > 1 {
+		return m.repeat
+	}
+	return 1
+}
+
+// frameText returns the internal and external error text for a single
+// frame. If the frame's code has no metadata, or the metadata text is
+// empty, the default message for the code's HTTP status or the frame's
+// error message is used instead.
+func frameText(msg ErrMsg) (detail, message string) {
+	detail, message = msg.Error(), msg.Error()
+	if code, ok := LookupCode(msg.Code()); ok {
+		if "" != code.Detail() {
+			detail = code.Detail()
 		}
-		fmt.Fprintf(state, "%s", strings.Trim(str.String(), " \n\t"))
-	default:
-		// Externally-safe error message
-		fmt.Fprintf(state, err.Error())
+		if "" != code.String() {
+			message = code.String()
+		} else if str, ok := defaultMessageForStatus(code.HTTPStatus()); ok {
+			message = str
+		}
+	}
+	return detail, message
+}
+
+// externalText returns the message StatusFromError reports for err, or an
+// empty string if the stack is empty.
+func (err *Err) externalText() string {
+	if 0 == err.Len() {
+		return ""
 	}
+	return externalMessage(err.Code(), err.HTTPStatus())
 }
 
 // From creates a new error stack based on a provided error and returns it.
+// If err is an *Err, a copy of the stack is returned with the most recent
+// code set to code and err is left unchanged. Otherwise err is stored in
+// the new frame, so it's returned by Unwrapped and matched by errors.Is
+// and errors.As. If err is nil or a nil *Err, a nil *Err is returned, which
+// is not equal to nil once stored in an error; use FromIf to return the
+// result from a function returning error.
 func From(code Code, err error) *Err {
+	if nil == err {
+		return nil
+	}
 	if e, ok := err.(*Err); ok {
-		e.errs[len(e.errs)-1].SetCode(code)
-		err = e
-	} else {
-		err = &Err{
-			errs: []ErrMsg{Msg{
-				err:    err,
-				caller: getCaller(),
-				code:   code,
-				msg:    err.Error(),
-			}},
-			mux: &sync.Mutex{},
+		if nil == e {
+			return nil
+		}
+		e = e.Clone()
+		if k := len(e.errs) - 1; k >= 0 {
+			e.errs[k] = e.errs[k].SetCode(code)
+		}
+		return e
+	}
+
+	return &Err{
+		errs: []ErrMsg{Msg{
+			err:    err,
+			caller: getCaller(),
+			code:   code,
+			msg:    err.Error(),
+		}},
+		mux: &sync.Mutex{},
+	}
+}
+
+// FromIf is like From but returns an untyped nil error if err is nil or a
+// nil *Err, so call sites can return errors.FromIf(code, err) directly.
+func FromIf(code Code, err error) error {
+	if e := From(code, err); nil != e {
+		return e
+	}
+	return nil
+}
+
+// Frames returns a copy of the frames in the stack, most recent first.
+// Changes to the returned slice don't affect err.
+func (err *Err) Frames() []ErrMsg {
+	errs := err.stack()
+	for a, b := 0, len(errs)-1; a < b; a, b = a+1, b-1 {
+		errs[a], errs[b] = errs[b], errs[a]
+	}
+	return errs
+}
+
+// FullTrace returns the call stack captured by the deepest frame that has
+// one, which is the closest available stack to the root cause. Unlike
+// Trace, which returns one caller per frame, this is the full call stack at
+// the point an error was created or wrapped. It's empty if tracing was
+// disabled when the frames were created.
+func (err *Err) FullTrace() Trace {
+	for _, msg := range err.stack() {
+		if trace := msg.Trace(); len(trace) > 0 {
+			return trace
 		}
 	}
-	return err.(*Err)
+	return nil
 }
 
-// HTTPStatus returns the associated HTTP status code, if any. Otherwise, returns 200.
+// HTTPStatus returns the first meaningful HTTP status code found in the
+// stack, starting from the most recent frame. Frames whose codes don't set
+// a status, or set the generic 200 status, are skipped so that an outer
+// wrap doesn't mask the status of a deeper error. An explicitly set status,
+// including 500, is used. A status set on a frame with WithHTTPStatus is
+// always used. If no frame has a meaningful status, the status of the most
+// recent frame is returned.
 func (err *Err) HTTPStatus() int {
-	status := http.StatusOK
+	status, _ := err.statusFrame()
+	return status
+}
+
+// statusFrame returns the status returned by HTTPStatus and the frame it
+// was taken from, or nil if the stack is empty.
+func (err *Err) statusFrame() (int, ErrMsg) {
+	stack := err.stack()
+	for k := len(stack) - 1; k >= 0; k-- {
+		if msg, ok := stack[k].(Msg); ok && 0 != msg.httpStatus {
+			return msg.httpStatus, stack[k]
+		}
+		if code, ok := LookupCode(stack[k].Code()); ok {
+			if status, ok := explicitHTTPStatus(code); ok {
+				return status, stack[k]
+			}
+		}
+	}
+	if 0 == len(stack) {
+		return http.StatusInternalServerError, nil
+	}
+	return err.HTTPStatusStrict(), stack[len(stack)-1]
+}
+
+// HTTPStatusStrict returns the HTTP status code set on the most recent
+// frame with WithHTTPStatus or associated with its code, if any.
+// Otherwise, returns 500.
+func (err *Err) HTTPStatusStrict() int {
+	status := http.StatusInternalServerError
 	if err.Len() > 0 {
-		if code, ok := Codes[err.Last().Code()]; ok {
+		if msg, ok := err.Last().(Msg); ok && 0 != msg.httpStatus {
+			return msg.httpStatus
+		}
+		if code, ok := LookupCode(err.Last().Code()); ok {
 			status = code.HTTPStatus()
 		}
 	}
 	return status
 }
 
-// Last append an ErrMsg to the lst.
+/*
+Is implements the interface used by errors.Is. Frames are checked from
+the most recent to the root cause and the first match wins. For each
+frame:
+ 1. If target is an *Err or a Msg, the frame matches when it carries
+    the same code as the target (the leading code for an *Err).
+ 2. Otherwise the frame matches when its underlying error matches
+    target using errors.Is.
+*/
+func (err *Err) Is(target error) bool {
+	errs := err.stack()
+	for k := len(errs) - 1; k >= 0; k-- {
+		if msg, ok := errs[k].(interface{ Is(error) bool }); ok && msg.Is(target) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Join returns an error stack combining the non-nil errors in errs, or nil
+if there are none. Each error is added with Append, so the result is the
+same as appending each error to a nil *Err. Like errors.Join, the result
+is an untyped nil error rather than a nil *Err when there is nothing to
+join, so it compares equal to nil. A non-nil result is an *Err.
+*/
+func Join(errs ...error) error {
+	var joined *Err
+	for _, err := range errs {
+		joined = joined.Append(err)
+	}
+	return joined.ErrOrNil()
+}
+
+// Last returns the most recent frame in the stack. If the stack is empty, a
+// zero Msg is returned.
 func (err *Err) Last() ErrMsg {
+	if nil == err {
+		return Msg{}
+	}
 	err.Lock()
-	msg := err.errs[len(err.errs)-1]
-	err.Unlock()
-	return msg
+	defer err.Unlock()
+	if 0 == len(err.errs) {
+		return Msg{}
+	}
+	return err.errs[len(err.errs)-1]
+}
+
+// limit drops the oldest frames after the root cause until the stack is
+// within MaxStackDepth. The caller must hold the lock.
+func (err *Err) limit() {
+	max := MaxStackDepth()
+	if 0 == max {
+		return
+	}
+	if drop := len(err.errs) - max; drop > 0 {
+		err.errs = append(err.errs[:1], err.errs[1+drop:]...)
+		err.elided += drop
+	}
 }
 
 // Len returns the size of the error stack.
 func (err *Err) Len() int {
+	if nil == err {
+		return 0
+	}
 	err.Lock()
 	length := len(err.errs)
 	err.Unlock()
@@ -217,16 +1024,19 @@ func (err *Err) Len() int {
 
 // Lock locks the error mutex.
 func (err *Err) Lock() {
-	errMux.Lock()
-	if nil == err.mux {
-		err.mux = &sync.Mutex{}
-	}
-	errMux.Unlock()
-
-	err.mux.Lock()
+	err.mutex().Lock()
 }
 
-var errMux = &sync.Mutex{}
+// mutex returns the error mutex, initializing it exactly once so a zero
+// value Err can never lock and unlock different mutex instances.
+func (err *Err) mutex() *sync.Mutex {
+	err.once.Do(func() {
+		if nil == err.mux {
+			err.mux = &sync.Mutex{}
+		}
+	})
+	return err.mux
+}
 
 // Msg returns the error message.
 func (err *Err) Msg() string {
@@ -241,114 +1051,443 @@ func (err *Err) Msg() string {
 func (err *Err) Push(e ...ErrMsg) *Err {
 	err.Lock()
 	err.errs = append(err.errs, e...)
+	if len(e) > 0 {
+		err.joined = 0
+	}
+	err.limit()
 	err.Unlock()
 	return err
 }
 
+// sortedKeys returns the keys of fields in sorted order.
+func sortedKeys(fields map[string]interface{}) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Range calls fn for each frame in the stack, from the most recent to the
+// root cause, stopping if fn returns false. i is the frame's index in the
+// stack, where the root cause is 0. fn receives a snapshot of the stack, so
+// it may safely call methods on err.
+func (err *Err) Range(fn func(i int, m ErrMsg) bool) {
+	errs := err.stack()
+	for k := len(errs) - 1; k >= 0; k-- {
+		if !fn(k, errs[k]) {
+			return
+		}
+	}
+}
+
+// Reset clears the error stack, keeping its capacity, so the error can be
+// reused from a sync.Pool. A reset error behaves like a new, empty error.
+// Frames returned before the reset are unaffected, but the error must not
+// be reused while it may still be referenced elsewhere.
+func (err *Err) Reset() {
+	err.Lock()
+	defer err.Unlock()
+	for k := range err.errs {
+		err.errs[k] = nil
+	}
+	err.errs = err.errs[:0]
+	err.joined, err.first, err.elided = 0, "", 0
+}
+
+// Root returns the root cause frame of an error stack, or nil if the
+// stack is empty.
+func (err *Err) Root() ErrMsg {
+	err.Lock()
+	defer err.Unlock()
+	if len(err.errs) > 0 {
+		return err.errs[0]
+	}
+	return nil
+}
+
+// RootCode returns the error code of the root cause frame. If the stack is
+// empty, ErrUnknown is returned.
+func (err *Err) RootCode() Code {
+	if root := err.Root(); nil != root {
+		return root.Code()
+	}
+	return ErrUnknown
+}
+
+// SameCause reports whether a and b have the same root cause, regardless of
+// the frames, messages and codes wrapping it. This allows failures reported
+// with different context to be grouped, such as two stacks wrapping the
+// same sentinel error. The root cause of an *Err is the error returned by
+// Unwrapped, and the root cause of any other error is found with
+// errors.Unwrap. Causes are compared with ==, or reflect.DeepEqual if
+// their type isn't comparable.
+func SameCause(a, b error) bool {
+	a, b = rootCause(a), rootCause(b)
+	if nil == a || nil == b {
+		return a == b
+	}
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return false
+	}
+	if reflect.TypeOf(a).Comparable() {
+		return a == b
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// rootCause returns the innermost error wrapped by err. For an *Err whose
+// root frame doesn't store an error, the root frame is returned.
+func rootCause(err error) error {
+	for nil != err {
+		if e, ok := err.(*Err); ok {
+			if nil == e || 0 == e.Len() {
+				return nil
+			}
+			next := e.Unwrapped()
+			if nil == next {
+				return e.Root()
+			}
+			err = next
+			continue
+		}
+		next := errors.Unwrap(err)
+		if nil == next {
+			return err
+		}
+		err = next
+	}
+	return nil
+}
+
+// sameFrame reports whether two frames share the same file, line and code.
+func sameFrame(a, b ErrMsg) bool {
+	if a.Code() != b.Code() || nil == a.Caller() || nil == b.Caller() {
+		return false
+	}
+	return a.Caller().File() == b.Caller().File() && a.Caller().Line() == b.Caller().Line()
+}
+
+/*
+Sentinel returns an error for use as a package-level sentinel value:
+
+	var ErrNotFound = errors.Sentinel(CodeNotFound, "not found")
+
+No caller or call stack information is captured, since it would only
+describe package initialization. Errors wrapping the sentinel match it
+with errors.Is, as does any error carrying the same code, so each sentinel
+should have its own code. Sentinels are shared, so they should be wrapped
+rather than modified with Push, With or the other mutating methods.
+*/
+func Sentinel(code Code, msg string) *Err {
+	str, e := formatMsg(msg, nil)
+	return newBare(code, e, str)
+}
+
+// SetCode sets the code of the most recent frame in the stack and returns
+// the error. Deeper frames keep their codes, so RootCode and FirstCode are
+// unaffected unless the stack has a single frame.
+func (err *Err) SetCode(code Code) *Err {
+	err.Lock()
+	defer err.Unlock()
+	if k := len(err.errs) - 1; k >= 0 {
+		err.errs[k] = err.errs[k].SetCode(code)
+	}
+	return err
+}
+
+// stackElided returns a copy of the error stack and the number of frames
+// dropped from it to stay within MaxStackDepth.
+func (err *Err) stackElided() ([]ErrMsg, int) {
+	if nil == err {
+		return nil, 0
+	}
+	err.Lock()
+	defer err.Unlock()
+	return append([]ErrMsg(nil), err.errs...), err.elided
+}
+
+// stack returns a copy of the error stack, taken under lock, so it can be
+// read safely while other goroutines push to the error.
+func (err *Err) stack() []ErrMsg {
+	if nil == err {
+		return nil
+	}
+	err.Lock()
+	defer err.Unlock()
+	return append([]ErrMsg(nil), err.errs...)
+}
+
 // String implements the stringer and Coder interfaces.
 func (err *Err) String() string {
 	return fmt.Sprintf("%v", err)
 }
 
-// Trace returns the call stack.
+// Trace returns the caller of each frame in the stack, root cause first.
+// This is the order the frames are stored in, so the index of each caller
+// matches the #N index printed by the %+v and %#v formats. Use
+// TraceReversed for the callers in the order they are printed.
 func (err *Err) Trace() Trace {
 	var callers Trace
-	for _, msg := range err.errs {
+	for _, msg := range err.stack() {
 		callers = append(callers, msg.Caller())
 	}
 	return callers
 }
 
-// Unlock locks the error mutex.
+// TraceReversed returns the caller of each frame in the stack, most recent
+// first, matching the order of Frames and of the formatted stack trace.
+func (err *Err) TraceReversed() Trace {
+	callers := err.Trace()
+	for a, b := 0, len(callers)-1; a < b; a, b = a+1, b-1 {
+		callers[a], callers[b] = callers[b], callers[a]
+	}
+	return callers
+}
+
+// Unlock unlocks the error mutex.
 func (err *Err) Unlock() {
-	err.mux.Unlock()
+	err.mutex().Unlock()
+}
+
+// Unwrapped returns the underlying error stored in the root cause frame,
+// such as an io.EOF or *os.PathError that was wrapped, so it can be
+// type-asserted. Unlike Cause, which returns the root frame itself, the
+// original error is returned. nil is returned if the stack is empty or the
+// root frame doesn't store an error.
+func (err *Err) Unwrapped() error {
+	if root, ok := err.Root().(interface{ Unwrap() error }); ok {
+		return root.Unwrap()
+	}
+	return nil
+}
+
+// updateLast replaces the most recent error in the stack with the result
+// of fn. Frames that aren't a Msg are left unchanged.
+func (err *Err) updateLast(fn func(Msg) Msg) *Err {
+	err.Lock()
+	defer err.Unlock()
+	if k := len(err.errs) - 1; k >= 0 {
+		if msg, ok := err.errs[k].(Msg); ok {
+			err.errs[k] = fn(msg)
+		}
+	}
+	return err
 }
 
 // With adds a new error to the stack without changing the leading cause.
+// If e is an *Err or a Msg, its frames are added behind a frame for msg.
+// Frames created by With have the code returned by DefaultCode. If data is
+// provided msg is used as a format string, otherwise msg is used as-is.
 func (err *Err) With(e error, msg string, data ...interface{}) *Err {
 	// Can't include a nil...
 	if nil == e {
 		return err
 	}
 
-	if err.Len() == 0 {
-		err = err.Push(Msg{
+	str, strErr := formatMsg(msg, data)
+	caller := getCaller()
+	code := DefaultCode()
+
+	// Frames to insert behind the leading error
+	var frames []ErrMsg
+	if msgs, ok := e.(*Err); ok {
+		frames = append([]ErrMsg{Msg{
+			err:    strErr,
+			caller: caller,
+			code:   code,
+			msg:    str,
+		}}, msgs.stack()...)
+	} else if msgs, ok := e.(Msg); ok {
+		frames = []ErrMsg{Msg{
+			err:    strErr,
+			caller: caller,
+			code:   code,
+			msg:    str,
+		}, msgs}
+	} else {
+		frames = []ErrMsg{Msg{
 			err:    e,
-			caller: getCaller(),
-			code:   0,
-			msg:    fmt.Sprintf(msg, data...),
+			caller: caller,
+			code:   code,
+			msg:    str,
+		}}
+	}
+
+	err.Lock()
+	defer err.Unlock()
+	if 0 == len(err.errs) {
+		err.errs = append(err.errs, Msg{
+			err:    e,
+			caller: caller,
+			code:   code,
+			msg:    str,
 		})
 	} else {
-		top := err.Last()
-		err.errs = err.errs[:len(err.errs)-1]
-		if msgs, ok := e.(Err); ok {
-			err = err.Push(Msg{
-				err:    fmt.Errorf(msg, data...),
-				caller: getCaller(),
-				code:   0,
-				msg:    fmt.Sprintf(msg, data...),
-			})
-			err = err.Push(msgs.errs...)
-		} else if msgs, ok := e.(Msg); ok {
-			err = err.Push(Msg{
-				err:    fmt.Errorf(msg, data...),
-				caller: getCaller(),
-				code:   0,
-				msg:    err.Error(),
-			}, msgs)
-		} else {
-			err = err.Push(Msg{
-				err:    e,
-				caller: getCaller(),
-				code:   0,
-				msg:    fmt.Sprintf(msg, data...),
-			})
-		}
-		err = err.Push(top)
+		k := len(err.errs) - 1
+		top := err.errs[k]
+		err.errs = append(append(err.errs[:k], frames...), top)
 	}
+	err.limit()
 
 	return err
 }
 
-/*
-type FormatOut struct {
-	TraceNum string `json:"stack"`
-	Error    string `json:"error"`
-	File     string `json:"file"`
-	Caller   string `json:"caller"`
-	Detail   string `json:"detail"`
+// WithCaller replaces the caller of the most recent frame with a synthetic
+// caller at line in file, within the function named fn. This is useful when
+// rebuilding errors received from another process, so that formatted output
+// shows where the error originally occurred. fn should be fully qualified,
+// like "github.com/lkcloud/errors.New".
+func (err *Err) WithCaller(file string, line int, fn string) *Err {
+	caller := Call{file: file, line: line, ok: true, function: fn}
+	return err.updateLast(func(msg Msg) Msg {
+		msg.caller = caller
+		return msg
+	})
 }
 
-func (err Msg) FormatString(k int) string {
-	code, ok := Codes[err.Code()]
-	if !ok {
-		code = ErrCode{
-			Int: err.Error(),
-			Ext: err.Error(),
+// WithField attaches a key/value pair to the most recent error in the
+// stack.
+func (err *Err) WithField(key string, value interface{}) *Err {
+	return err.WithFields(map[string]interface{}{key: value})
+}
+
+// WithFields attaches structured metadata to the most recent error in the
+// stack. Existing fields with the same key are replaced.
+func (err *Err) WithFields(fields map[string]interface{}) *Err {
+	err.Lock()
+	defer err.Unlock()
+	if k := len(err.errs) - 1; k >= 0 {
+		if msg, ok := err.errs[k].(interface {
+			WithFields(map[string]interface{}) ErrMsg
+		}); ok {
+			err.errs[k] = msg.WithFields(fields)
 		}
 	}
+	return err
+}
 
-	errMsgInt := fmt.Sprintf("%d", err.Code())
-	if "" != code.Detail() {
-		errMsgInt = fmt.Sprintf("%s (code:%s)", code.Detail(), errMsgInt)
-	} else {
-		errMsgInt = fmt.Sprintf("%s (code:%s)", err.Error(), errMsgInt)
+// WithHTTPStatus sets the HTTP status of the most recent error in the
+// stack, overriding the status associated with its code. The gRPC status
+// is derived from the new status unless the code sets an explicit gRPC
+// status.
+func (err *Err) WithHTTPStatus(status int) *Err {
+	return err.updateLast(func(msg Msg) Msg {
+		msg.httpStatus = status
+		return msg
+	})
+}
+
+// WithStack replaces the call stack of the most recent error in the stack
+// with the current call stack, so an error that is returned far from where
+// it was created, such as from another goroutine, records where it was
+// rethrown. The caller of each frame is unchanged.
+func (err *Err) WithStack() *Err {
+	trace := getCallerTrace()
+	return err.updateLast(func(msg Msg) Msg {
+		msg.trace = trace
+		msg.stack = nil
+		return msg
+	})
+}
+
+// Wrap wraps an error into a new stack led by msg. If data is provided msg
+// is used as a format string, otherwise msg is used as-is. The call stack
+// is captured for the new frame if tracing is enabled.
+func Wrap(err error, code Code, msg string, data ...interface{}) *Err {
+	str, e := formatMsg(msg, data)
+	return wrap(err, code, e, str, false, 0)
+}
+
+// WrapBare is like Wrap but doesn't capture caller or call stack
+// information.
+func WrapBare(err error, code Code, msg string, data ...interface{}) *Err {
+	str, e := formatMsg(msg, data)
+	return wrap(err, code, e, str, true, 0)
+}
+
+// WrapContext wraps an error into a new stack led by msg without changing
+// the reported code. The new frame inherits the leading code of err, or
+// ErrUnknown if err doesn't have one.
+func WrapContext(err error, msg string, data ...interface{}) *Err {
+	code := ErrUnknown
+	if e, ok := err.(*Err); ok && e.Len() > 0 {
+		code = e.Code()
+	} else if e, ok := err.(Msg); ok {
+		code = e.Code()
 	}
+	str, e := formatMsg(msg, data)
+	return wrap(err, code, e, str, false, 0)
+}
 
-	out := FormatOut{
-		TraceNum: fmt.Sprintf("#%d", k),
-		Error:    err.Msg(),
-		Caller:   fmt.Sprintf("%s:%d:%s", path.Base(err.Caller().File()), err.Caller().Line(), runtime.FuncForPC(err.Caller().Pc()).Name()),
-		Detail:   errMsgInt,
+// WrapIf is like Wrap but returns nil if err is nil, shortening call sites
+// to return errors.WrapIf(err, code, "..."). The result is an untyped nil
+// error rather than a nil *Err, so it compares equal to nil.
+func WrapIf(err error, code Code, msg string, data ...interface{}) error {
+	if nil == err {
+		return nil
 	}
+	str, e := formatMsg(msg, data)
+	return wrap(err, code, e, str, false, 0)
+}
+
+// WrapSkip is like Wrap but skips an additional skip caller frames when
+// capturing caller information, like runtime.Caller.
+func WrapSkip(skip int, err error, code Code, msg string, data ...interface{}) *Err {
+	str, e := formatMsg(msg, data)
+	return wrap(err, code, e, str, false, skip)
+}
 
-	data, _ := json.Marshal(out)
-	return string(data)
+// Wrapf wraps an error into a new stack led by a message, using format as
+// a format string even when no arguments are provided.
+func Wrapf(err error, code Code, format string, args ...interface{}) *Err {
+	e := fmt.Errorf(format, args...)
+	return wrap(err, code, e, e.Error(), false, 0)
 }
+
+/*
+WrapAll wraps a batch of sibling errors, such as the failures of several
+concurrent uploads, into a new stack led by a single summarizing message:
+
+	err := errors.WrapAll(failed, CodeUploadFailed, "%d of %d uploads failed", len(failed), total)
+
+Each non-nil error keeps its own stack, available from Children, and the
+result matches anything one of its children matches with errors.Is. Errors
+that aren't an *Err are added as a single frame with the ErrUnknown code.
+If data is provided msg is used as a format string, otherwise msg is used
+as-is.
 */
+func WrapAll(errs []error, code Code, msg string, data ...interface{}) *Err {
+	var children []*Err
+	for _, e := range errs {
+		switch child := e.(type) {
+		case nil:
+		case *Err:
+			if nil != child {
+				children = append(children, child)
+			}
+		case Msg:
+			children = append(children, &Err{
+				errs: []ErrMsg{child},
+				mux:  &sync.Mutex{},
+			})
+		default:
+			children = append(children, From(ErrUnknown, e))
+		}
+	}
 
-// Wrap wraps an error into a new stack led by msg.
-func Wrap(err error, code Code, msg string, data ...interface{}) *Err {
+	str, e := formatMsg(msg, data)
+	return newErr(0, code, e, str).updateLast(func(msg Msg) Msg {
+		msg.children = children
+		return msg
+	})
+}
+
+// wrap wraps an error into a new stack led by e. If bare is true no
+// caller information is captured, otherwise skip is the number of
+// additional caller frames to skip.
+func wrap(err error, code Code, e error, msg string, bare bool, skip int) *Err {
 	var errs = &Err{
 		errs: []ErrMsg{},
 		mux:  &sync.Mutex{},
@@ -356,45 +1495,62 @@ func Wrap(err error, code Code, msg string, data ...interface{}) *Err {
 
 	// Can't wrap a nil...
 	if nil == err {
-		return New(code, msg)
+		if bare {
+			return newBare(code, e, msg)
+		}
+		return newErr(skip, code, e, msg)
+	}
+
+	var caller Caller = Call{}
+	if !bare {
+		caller = getCallerSkip(skip)
 	}
 
 	if e, ok := err.(*Err); ok {
-		errs.Push(e.errs...)
+		errs.errs, errs.elided = e.stackElided()
 	} else if e, ok := err.(Msg); ok {
 		errs.Push(e)
 	} else {
 		errs = &Err{
 			errs: []ErrMsg{Msg{
 				err:    err,
-				caller: getCaller(),
-				code:   0,
+				caller: caller,
+				code:   DefaultCode(),
 				msg:    err.Error(),
 			}},
 			mux: &sync.Mutex{},
 		}
 	}
 
-	errs.Push(Msg{
-		err:    fmt.Errorf(msg, data...),
-		caller: getCaller(),
+	frame := Msg{
+		err:    e,
+		caller: caller,
 		code:   code,
-		msg:    fmt.Sprintf(msg, data...),
-	})
+		msg:    msg,
+	}
+	if !bare {
+		frame = traceMsg(frame)
+	}
+	errs.Push(frame)
 
 	return errs
 }
 
-func DecodeErr(err error) (Code, string) {
-	if err == nil {
-		return ErrSuccess, Codes[ErrSuccess].String()
-	}
-
-	switch typed := err.(type) {
-	case *Err:
-		return typed.Code(), typed.String()
-	default:
+// formatMsg returns the message text and error for msg formatted with
+// data. If no data is provided msg is used as-is, so messages containing
+// a '%' aren't mangled. The message is formatted once and the text of the
+// returned error is used, so the %w verb is supported.
+func formatMsg(msg string, data []interface{}) (string, error) {
+	if 0 == len(data) {
+		return msg, errors.New(msg)
 	}
+	err := fmt.Errorf(msg, data...)
+	return err.Error(), err
+}
 
-	return ErrUnknown, Codes[ErrUnknown].String()
+// DecodeErr returns the leading code of err and the message reported for
+// it by StatusFromError, for callers that don't need the HTTP status.
+func DecodeErr(err error) (Code, string) {
+	_, code, message := StatusFromError(err)
+	return code, message
 }
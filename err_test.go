@@ -1,8 +1,15 @@
 package errors
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -92,3 +99,1614 @@ func TestWith(t *testing.T) {
 		t.Errorf("Expected 'err 1', received %s", err.Error())
 	}
 }
+
+func TestIs(t *testing.T) {
+	sentinel := errors.New("sentinel")
+	err := Wrap(sentinel, ErrInvalidJSON, "decode failed")
+	err = Wrap(err, ErrFatal, "load failed")
+
+	// Code-based matches consider every frame in the stack
+	if !errors.Is(err, ErrInvalidJSON.AsError()) {
+		t.Errorf("Expected a match on code %d", ErrInvalidJSON)
+	}
+	if !errors.Is(err, ErrFatal.AsError()) {
+		t.Errorf("Expected a match on code %d", ErrFatal)
+	}
+	if !errors.Is(err, Msg{code: ErrInvalidJSON}) {
+		t.Errorf("Expected a Msg match on code %d", ErrInvalidJSON)
+	}
+	if errors.Is(err, ErrDecodingToml.AsError()) {
+		t.Errorf("Expected no match on code %d", ErrDecodingToml)
+	}
+
+	// Value-based matches use the underlying error of each frame
+	if !errors.Is(err, sentinel) {
+		t.Errorf("Expected a match on the wrapped sentinel")
+	}
+	if errors.Is(err, io.EOF) {
+		t.Errorf("Expected no match on io.EOF")
+	}
+	err = Wrap(fmt.Errorf("read: %w", io.EOF), ErrUnknown, "read failed")
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("Expected a match on a nested io.EOF")
+	}
+}
+
+type opMsg struct {
+	ErrMsg
+	op string
+}
+
+func (msg opMsg) Operation() string {
+	return msg.op
+}
+
+func TestBreadcrumbs(t *testing.T) {
+	err := Wrap(io.EOF, ErrDecodingJSON, "decode")
+	err.Push(opMsg{ErrMsg: Msg{msg: "fetching profile"}, op: "fetch profile"})
+	err.Push(opMsg{ErrMsg: Msg{msg: "login failed"}, op: ""})
+
+	expect := []string{"EOF", "decode", "fetch profile", "login failed"}
+	crumbs := err.Breadcrumbs()
+	if len(expect) != len(crumbs) {
+		t.Fatalf("Expected %d breadcrumbs, received %d", len(expect), len(crumbs))
+	}
+	for k, crumb := range crumbs {
+		if expect[k] != crumb {
+			t.Errorf("Expected '%s' at %d, received '%s'", expect[k], k, crumb)
+		}
+	}
+}
+
+type opError struct {
+	op string
+}
+
+func (err *opError) Error() string {
+	return err.op + " failed"
+}
+
+func TestAs(t *testing.T) {
+	err := Wrap(&opError{op: "dial"}, ErrInvalidJSON, "decode failed")
+	err = Wrap(err, ErrFatal, "load failed")
+
+	var msg Msg
+	if !errors.As(err, &msg) {
+		t.Fatalf("Expected a Msg to be extracted")
+	}
+	if "load failed" != msg.Msg() {
+		t.Errorf("Expected 'load failed', received '%s'", msg.Msg())
+	}
+
+	var code Code
+	if !errors.As(err, &code) {
+		t.Fatalf("Expected a Code to be extracted")
+	}
+	if ErrFatal != code {
+		t.Errorf("Expected %d, received %d", ErrFatal, code)
+	}
+
+	var opErr *opError
+	if !errors.As(err, &opErr) {
+		t.Fatalf("Expected an *opError to be extracted")
+	}
+	if "dial" != opErr.op {
+		t.Errorf("Expected 'dial', received '%s'", opErr.op)
+	}
+
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		t.Errorf("Expected no *os.PathError to be extracted")
+	}
+}
+
+func TestConcurrentLock(t *testing.T) {
+	var err Err
+	wg := sync.WaitGroup{}
+	for a := 0; a < 100; a++ {
+		wg.Add(1)
+		go func(a int) {
+			defer wg.Done()
+			err.Push(Msg{msg: fmt.Sprintf("msg %d", a)})
+			if err.Len() < 1 {
+				t.Errorf("Expected at least 1 message")
+			}
+			if "" == err.Last().Msg() {
+				t.Errorf("Expected a message")
+			}
+		}(a)
+	}
+	wg.Wait()
+
+	if 100 != err.Len() {
+		t.Errorf("Expected 100, received %d", err.Len())
+	}
+}
+
+func TestConcurrentFormat(t *testing.T) {
+	err := New(ErrFatal, "new")
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for a := 0; a < 100; a++ {
+			err.Push(Msg{caller: getCaller(), msg: fmt.Sprintf("msg %d", a)})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for a := 0; a < 100; a++ {
+			_ = err.Trace()
+			_ = err.Cause()
+			_ = fmt.Sprintf("%+v", err)
+		}
+	}()
+	wg.Wait()
+
+	if 101 != len(err.Trace()) {
+		t.Errorf("Expected 101, received %d", len(err.Trace()))
+	}
+}
+
+func TestFields(t *testing.T) {
+	err := New(ErrUnknown, "root").
+		WithField("user_id", 42).
+		WithField("request_id", "abc")
+	err = Wrap(err, ErrFatal, "wrapped").WithFields(map[string]interface{}{
+		"request_id": "def",
+		"attempt":    3,
+	})
+
+	// Fields survive Wrap and more recent frames take precedence
+	fields := err.Fields()
+	if 3 != len(fields) {
+		t.Errorf("Expected 3 fields, received %d", len(fields))
+	}
+	if 42 != fields["user_id"] {
+		t.Errorf("Expected 42, received %v", fields["user_id"])
+	}
+	if "def" != fields["request_id"] {
+		t.Errorf("Expected 'def', received %v", fields["request_id"])
+	}
+	if 3 != fields["attempt"] {
+		t.Errorf("Expected 3, received %v", fields["attempt"])
+	}
+
+	// Fields are stored on the frame they were attached to
+	root := err.errs[0].(Msg).Fields()
+	if "abc" != root["request_id"] {
+		t.Errorf("Expected 'abc', received %v", root["request_id"])
+	}
+
+	if 0 != len(New(ErrUnknown, "none").Fields()) {
+		t.Errorf("Expected no fields")
+	}
+}
+
+func TestFieldsThroughWrap(t *testing.T) {
+	inner := New(ErrFatal, "query failed").WithFields(map[string]interface{}{
+		"table":      "users",
+		"request_id": "inner",
+	})
+
+	// Fields of an *Err wrapped by another error are kept
+	err := Wrap(fmt.Errorf("load: %w", inner), ErrUnknown, "load failed").
+		WithField("request_id", "outer")
+	expect := map[string]interface{}{"table": "users", "request_id": "outer"}
+	if fields := err.Fields(); !reflect.DeepEqual(expect, fields) {
+		t.Errorf("Expected %v, received %v", expect, fields)
+	}
+
+	// With combines the stacks and the receiver's leading frame takes
+	// precedence
+	outer := New(ErrUnknown, "sync failed").WithFields(map[string]interface{}{
+		"request_id": "outer",
+		"attempt":    2,
+	})
+	outer.With(inner, "while syncing")
+	expect = map[string]interface{}{"table": "users", "request_id": "outer", "attempt": 2}
+	if fields := outer.Fields(); !reflect.DeepEqual(expect, fields) {
+		t.Errorf("Expected %v, received %v", expect, fields)
+	}
+	if fields := outer.With(fmt.Errorf("cache: %w", inner), "while caching").Fields(); "users" != fields["table"] {
+		t.Errorf("Expected 'users', received %v", fields["table"])
+	}
+}
+
+func TestFormatFields(t *testing.T) {
+	err := &Err{errs: []ErrMsg{
+		Msg{
+			err:    errors.New("read: end of input"),
+			caller: Call{file: "/src/config/read.go", line: 12},
+			code:   ErrDecodingJSON,
+			msg:    "read: end of input",
+		},
+		Msg{
+			err:    errors.New("could not load configuration"),
+			caller: Call{file: "/src/config/load.go", line: 30},
+			code:   ErrFatal,
+			msg:    "could not load configuration",
+			fields: map[string]interface{}{"user_id": 42, "request_id": "abc"},
+		},
+	}}
+
+	expect := "#1: `<no caller>`\n" +
+		"\terror:   could not load configuration\n" +
+		"\tline:    load.go:30\n" +
+		"\tdetail:  a fatal error occurred (code:2)\n" +
+		"\tmessage: a fatal error occurred (code:2)\n" +
+		"\tfields:\n" +
+		"\t\trequest_id: abc\n" +
+		"\t\tuser_id: 42\n" +
+		"#0: `<no caller>`\n" +
+		"\terror:   read: end of input\n" +
+		"\tline:    read.go:12\n" +
+		"\tdetail:  JSON data could not be decoded (code:101)\n" +
+		"\tmessage: JSON data could not be decoded (code:101)"
+	if out := fmt.Sprintf("%+v", err); expect != out {
+		t.Errorf("Expected:\n%s\nreceived:\n%s", expect, out)
+	}
+}
+
+func TestClone(t *testing.T) {
+	err := Wrap(errors.New("root"), ErrDecodingJSON, "decode failed")
+	clone := err.Clone()
+
+	clone.errs[1] = clone.errs[1].SetCode(ErrFatal)
+	clone.Push(Msg{msg: "pushed"})
+	clone.WithField("user_id", 42)
+
+	if ErrDecodingJSON != err.Code() {
+		t.Errorf("Expected %d, received %d", ErrDecodingJSON, err.Code())
+	}
+	if 2 != err.Len() {
+		t.Errorf("Expected 2, received %d", err.Len())
+	}
+	if 0 != len(err.Fields()) {
+		t.Errorf("Expected no fields, received %v", err.Fields())
+	}
+	if ErrFatal != clone.errs[1].Code() {
+		t.Errorf("Expected %d, received %d", ErrFatal, clone.errs[1].Code())
+	}
+}
+
+func TestFromDoesNotMutate(t *testing.T) {
+	err := New(ErrDecodingJSON, "decode failed")
+	from := From(ErrFatal, err)
+
+	if ErrDecodingJSON != err.Code() {
+		t.Errorf("Expected %d, received %d", ErrDecodingJSON, err.Code())
+	}
+	if ErrFatal != from.Code() {
+		t.Errorf("Expected %d, received %d", ErrFatal, from.Code())
+	}
+	if err == from {
+		t.Errorf("Expected From to return a new error")
+	}
+}
+
+func TestFromReachesCause(t *testing.T) {
+	cause := &os.PathError{Op: "open", Path: "config.json", Err: os.ErrNotExist}
+	err := From(ErrFatal, cause)
+	if cause != err.Unwrapped() {
+		t.Errorf("Expected the original error, received %v", err.Unwrapped())
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected errors.Is to reach the original error")
+	}
+	var pathErr *os.PathError
+	if !errors.As(err, &pathErr) || cause != pathErr {
+		t.Errorf("Expected errors.As to reach the original error")
+	}
+
+	if nil != From(ErrFatal, nil) {
+		t.Errorf("Expected nil for a nil error")
+	}
+}
+
+func TestFromNil(t *testing.T) {
+	var maybeNilErr error
+	if nil != From(ErrFatal, maybeNilErr) {
+		t.Errorf("Expected nil for a nil error")
+	}
+
+	// A nil *Err stored in an error interface isn't a nil error
+	var nilErr *Err
+	maybeNilErr = nilErr
+	if nil != From(ErrFatal, maybeNilErr) {
+		t.Errorf("Expected nil for a nil *Err")
+	}
+}
+
+func TestFromIf(t *testing.T) {
+	fromIf := func(err error) error { return FromIf(ErrFatal, err) }
+
+	if e := fromIf(nil); nil != e {
+		t.Errorf("Expected nil, received %#v", e)
+	}
+	var nilErr *Err
+	if e := fromIf(nilErr); nil != e {
+		t.Errorf("Expected nil for a nil *Err, received %#v", e)
+	}
+
+	cause := errors.New("read failed")
+	e := fromIf(cause)
+	if nil == e {
+		t.Fatalf("Expected an error")
+	}
+	if ErrFatal != CodeOf(e) {
+		t.Errorf("Expected %d, received %d", ErrFatal, CodeOf(e))
+	}
+	if !errors.Is(e, cause) {
+		t.Errorf("Expected errors.Is to reach the original error")
+	}
+}
+
+func TestLiteralMessages(t *testing.T) {
+	tests := []*Err{
+		New(ErrUnknown, "100% failure"),
+		ErrUnknown.New("100% failure"),
+		Wrap(errors.New("root"), ErrUnknown, "100% failure"),
+		Wrap(nil, ErrUnknown, "100% failure"),
+		ErrUnknown.Wrap(errors.New("root"), "100% failure"),
+		Newf(ErrUnknown, "%d%% failure", 100),
+		Wrapf(errors.New("root"), ErrUnknown, "%d%% failure", 100),
+		New(ErrUnknown, "%d%% failure", 100),
+	}
+	for k, err := range tests {
+		if "100% failure" != err.Error() {
+			t.Errorf("Expected '100%% failure' at %d, received '%s'", k, err.Error())
+		}
+		if "100% failure" != err.Msg() {
+			t.Errorf("Expected '100%% failure' at %d, received '%s'", k, err.Msg())
+		}
+	}
+
+	if err := Wrap(nil, ErrUnknown, "%d failures", 3); "3 failures" != err.Msg() {
+		t.Errorf("Expected '3 failures', received '%s'", err.Msg())
+	}
+}
+
+func TestFormatLiteral(t *testing.T) {
+	err := New(errTestCode, "expected %d items")
+	if "expected %d items (code:9000)" != fmt.Sprintf("%v", err) {
+		t.Errorf("Expected 'expected %%d items (code:9000)', received '%v'", err)
+	}
+	if "expected %d items" != fmt.Sprintf("%s", err) {
+		t.Errorf("Expected 'expected %%d items', received '%s'", err)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	build := func() *Err {
+		err := Wrap(errors.New("root"), ErrDecodingJSON, "decode failed")
+		return Wrap(err, ErrFatal, "load failed").WithField("user_id", 42)
+	}
+	a, b := build(), build()
+
+	if !Equal(a, b) {
+		t.Errorf("Expected equal stacks")
+	}
+	if !EqualCallers(a, b) {
+		t.Errorf("Expected equal callers")
+	}
+	c := Wrap(Wrap(errors.New("root"), ErrDecodingJSON, "decode failed"), ErrFatal, "load failed").
+		WithField("user_id", 42)
+	if !Equal(a, c) {
+		t.Errorf("Expected equal stacks")
+	}
+	if EqualCallers(a, c) {
+		t.Errorf("Expected different callers")
+	}
+	if !EqualCallers(a, a.Clone()) {
+		t.Errorf("Expected a clone to have equal callers")
+	}
+
+	if Equal(a, b.Clone().WithField("user_id", 7)) {
+		t.Errorf("Expected stacks with different fields to differ")
+	}
+	if Equal(a, From(ErrUnknown, b)) {
+		t.Errorf("Expected stacks with different codes to differ")
+	}
+	if Equal(a, Wrap(b, ErrFatal, "load failed")) {
+		t.Errorf("Expected stacks with different lengths to differ")
+	}
+	if Equal(a, Wrap(errors.New("root"), ErrDecodingJSON, "decode failed")) {
+		t.Errorf("Expected stacks with different messages to differ")
+	}
+
+	if !Equal(nil, nil) || Equal(a, nil) || Equal(nil, a) {
+		t.Errorf("Expected nil to only equal nil")
+	}
+	if !Equal(errors.New("plain"), errors.New("plain")) {
+		t.Errorf("Expected plain errors with the same text to be equal")
+	}
+	if Equal(errors.New("load failed"), New(ErrFatal, "load failed")) {
+		t.Errorf("Expected a plain error to differ from an *Err")
+	}
+}
+
+func TestBare(t *testing.T) {
+	err := WrapBare(NewBare(ErrDecodingJSON, "decode failed"), ErrFatal, "load failed")
+	err = WrapBare(err, ErrFatal, "%d%% failure", 100)
+
+	for _, msg := range err.errs {
+		if (Call{}) != msg.Caller() {
+			t.Errorf("Expected a zero caller, received %v", msg.Caller())
+		}
+		if nil != msg.Trace() {
+			t.Errorf("Expected no trace, received %v", msg.Trace())
+		}
+	}
+	if "100% failure" != err.Msg() {
+		t.Errorf("Expected '100%% failure', received '%s'", err.Msg())
+	}
+	if 2 != WrapBare(errors.New("root"), ErrFatal, "wrapped").Len() {
+		t.Errorf("Expected a wrapped plain error to have 2 frames")
+	}
+	if 1 != WrapBare(nil, ErrFatal, "wrapped").Len() {
+		t.Errorf("Expected a wrapped nil error to have 1 frame")
+	}
+
+	for _, verb := range []string{"%s", "%v", "%-v", "%#v", "%+v"} {
+		if "" == fmt.Sprintf(verb, err) {
+			t.Errorf("Expected %s output", verb)
+		}
+	}
+}
+
+func TestFormatNoCaller(t *testing.T) {
+	err := From(ErrFatal, errors.New("plain"))
+	err.errs[0] = Msg{err: errors.New("plain"), caller: Call{}, code: ErrFatal, msg: "plain"}
+
+	expect := `#0 - caller: "<no caller>" error: "plain" detail: "a fatal error occurred (code:2)"`
+	if out := fmt.Sprintf("%#v", err); expect != out {
+		t.Errorf("Expected '%s', received '%s'", expect, out)
+	}
+	if out := fmt.Sprintf("%-v", err); expect != out {
+		t.Errorf("Expected '%s', received '%s'", expect, out)
+	}
+
+	expect = "#0: `<no caller>`\n" +
+		"\terror:   plain\n" +
+		"\tline:    <no caller>\n" +
+		"\tdetail:  a fatal error occurred (code:2)\n" +
+		"\tmessage: a fatal error occurred (code:2)"
+	if out := fmt.Sprintf("%+v", err); expect != out {
+		t.Errorf("Expected:\n%s\nreceived:\n%s", expect, out)
+	}
+
+	// Nil callers are handled the same way
+	err.errs[0] = Msg{err: errors.New("plain"), code: ErrFatal, msg: "plain"}
+	if out := fmt.Sprintf("%+v", err); expect != out {
+		t.Errorf("Expected:\n%s\nreceived:\n%s", expect, out)
+	}
+
+	// From captures the caller of a plain error
+	err = From(ErrFatal, errors.New("plain"))
+	if out := fmt.Sprintf("%#v", err); strings.Contains(out, noCaller) {
+		t.Errorf("Expected caller information, received '%s'", out)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	nested := Wrap(errors.New("read failed"), ErrDecodingJSON, "decode failed")
+	err := Wrap(nested, ErrFatal, "load failed")
+	err = err.With(nested, "retry failed")
+
+	if 6 != err.Len() {
+		t.Fatalf("Expected 6 frames, received %d", err.Len())
+	}
+
+	flat := err.Flatten()
+	expect := []string{"read failed", "decode failed", "retry failed", "load failed"}
+	if len(expect) != flat.Len() {
+		t.Fatalf("Expected %d frames, received %d", len(expect), flat.Len())
+	}
+	for k, msg := range flat.errs {
+		if expect[k] != msg.Msg() {
+			t.Errorf("Expected '%s' at %d, received '%s'", expect[k], k, msg.Msg())
+		}
+	}
+
+	// The original stack is unchanged
+	if 6 != err.Len() {
+		t.Errorf("Expected 6 frames, received %d", err.Len())
+	}
+}
+
+func TestWithNestedErr(t *testing.T) {
+	nested := Wrap(errors.New("nested root"), ErrDecodingJSON, "nested decode")
+	nested = Wrap(nested, ErrDecodingToml, "nested load")
+
+	err := Wrap(errors.New("root"), ErrFatal, "top")
+	err = err.With(nested, "with nested")
+
+	expect := []string{"root", "with nested", "nested root", "nested decode", "nested load", "top"}
+	if len(expect) != err.Len() {
+		t.Fatalf("Expected %d frames, received %d", len(expect), err.Len())
+	}
+	for k, msg := range err.errs {
+		if expect[k] != msg.Msg() {
+			t.Errorf("Expected '%s' at %d, received '%s'", expect[k], k, msg.Msg())
+		}
+	}
+	if ErrFatal != err.Code() {
+		t.Errorf("Expected %d, received %d", ErrFatal, err.Code())
+	}
+	if !errors.Is(err, ErrDecodingToml.AsError()) {
+		t.Errorf("Expected the nested codes to be present")
+	}
+
+	// A Msg is added behind a frame for the message
+	err = New(ErrFatal, "top").With(Msg{msg: "msg", code: ErrDecodingJSON}, "with msg")
+	expect = []string{"with msg", "msg", "top"}
+	for k, msg := range err.errs {
+		if expect[k] != msg.Msg() {
+			t.Errorf("Expected '%s' at %d, received '%s'", expect[k], k, msg.Msg())
+		}
+	}
+}
+
+func TestHTTPStatus(t *testing.T) {
+	setTestCode(t, errTestCode, ErrCode{Ext: "not found", HTTP: 404})
+
+	// Only a middle frame has a real status
+	err := New(ErrDecodingJSON, "decode failed")
+	err = Wrap(err, errTestCode, "record missing")
+	err = Wrap(err, ErrUnknown, "request failed")
+
+	if 404 != err.HTTPStatus() {
+		t.Errorf("Expected 404, received %d", err.HTTPStatus())
+	}
+	if 500 != err.HTTPStatusStrict() {
+		t.Errorf("Expected 500, received %d", err.HTTPStatusStrict())
+	}
+
+	// No frame has a status
+	err = Wrap(New(ErrDecodingJSON, "decode failed"), ErrUnknown, "request failed")
+	if 500 != err.HTTPStatus() {
+		t.Errorf("Expected 500, received %d", err.HTTPStatus())
+	}
+}
+
+func TestHTTPStatusExplicit(t *testing.T) {
+	setTestCode(t, errTestCode, ErrCode{Ext: "not found", HTTP: 404})
+	setTestCode(t, errTestCode+1, ErrCode{Ext: "storage failed", HTTP: 500})
+
+	// An explicit 500 isn't masked by a deeper 404
+	err := Wrap(New(errTestCode, "record missing"), errTestCode+1, "storage failed")
+	if 500 != err.HTTPStatus() {
+		t.Errorf("Expected 500, received %d", err.HTTPStatus())
+	}
+
+	// Codes without a status are still skipped
+	err = Wrap(err, ErrFatal, "request failed")
+	if 500 != err.HTTPStatus() {
+		t.Errorf("Expected 500, received %d", err.HTTPStatus())
+	}
+	err = Wrap(New(errTestCode, "record missing"), ErrFatal, "request failed")
+	if 404 != err.HTTPStatus() {
+		t.Errorf("Expected 404, received %d", err.HTTPStatus())
+	}
+}
+
+func TestHTTPStatusUnmapped(t *testing.T) {
+	// Unmapped error codes
+	err := New(errTestCode, "unmapped")
+	if 500 != err.HTTPStatus() {
+		t.Errorf("Expected 500, received %d", err.HTTPStatus())
+	}
+	if 500 != err.HTTPStatusStrict() {
+		t.Errorf("Expected 500, received %d", err.HTTPStatusStrict())
+	}
+	if 500 != (&Err{}).HTTPStatus() {
+		t.Errorf("Expected 500, received %d", (&Err{}).HTTPStatus())
+	}
+
+	// Registered error codes without a status
+	if 500 != (ErrCode{Ext: "failed"}).HTTPStatus() {
+		t.Errorf("Expected 500, received %d", (ErrCode{Ext: "failed"}).HTTPStatus())
+	}
+
+	// Success
+	err = New(ErrSuccess, "ok")
+	if 200 != err.HTTPStatus() {
+		t.Errorf("Expected 200, received %d", err.HTTPStatus())
+	}
+}
+
+func TestWrapContext(t *testing.T) {
+	err := New(ErrDecodingJSON, "decode failed")
+	err = WrapContext(err, "loading %s", "config.json")
+
+	if 2 != err.Len() {
+		t.Errorf("Expected 2, received %d", err.Len())
+	}
+	if ErrDecodingJSON != err.Code() {
+		t.Errorf("Expected %d, received %d", ErrDecodingJSON, err.Code())
+	}
+	if "loading config.json" != err.Last().Msg() {
+		t.Errorf("Expected 'loading config.json', received '%s'", err.Last().Msg())
+	}
+	if "err_test.go" != path.Base(err.Caller().File()) {
+		t.Errorf("Expected 'err_test.go', received '%s'", path.Base(err.Caller().File()))
+	}
+
+	// Errors without a code are unknown
+	err = WrapContext(errors.New("read failed"), "loading config")
+	if ErrUnknown != err.Code() {
+		t.Errorf("Expected %d, received %d", ErrUnknown, err.Code())
+	}
+}
+
+func TestRoot(t *testing.T) {
+	err := New(ErrDecodingJSON, "decode failed")
+	err = Wrap(err, ErrDecodingToml, "fallback failed")
+	err = Wrap(err, ErrFatal, "load failed")
+
+	if "decode failed" != err.Root().Msg() {
+		t.Errorf("Expected 'decode failed', received '%s'", err.Root().Msg())
+	}
+	if ErrDecodingJSON != err.RootCode() {
+		t.Errorf("Expected %d, received %d", ErrDecodingJSON, err.RootCode())
+	}
+	if ErrFatal != err.Code() {
+		t.Errorf("Expected %d, received %d", ErrFatal, err.Code())
+	}
+
+	// Empty stacks
+	err = &Err{}
+	if nil != err.Root() {
+		t.Errorf("Expected nil, received %v", err.Root())
+	}
+	if ErrUnknown != err.RootCode() {
+		t.Errorf("Expected %d, received %d", ErrUnknown, err.RootCode())
+	}
+}
+
+func TestRange(t *testing.T) {
+	err := New(ErrDecodingJSON, "decode failed")
+	err = Wrap(err, ErrDecodingToml, "fallback failed")
+	err = Wrap(err, ErrFatal, "load failed")
+
+	// Most recent first
+	var indexes []int
+	var msgs []string
+	err.Range(func(i int, m ErrMsg) bool {
+		indexes = append(indexes, i)
+		msgs = append(msgs, m.Msg())
+		return true
+	})
+	if "[2 1 0]" != fmt.Sprint(indexes) {
+		t.Errorf("Expected [2 1 0], received %v", indexes)
+	}
+	if "load failed|fallback failed|decode failed" != strings.Join(msgs, "|") {
+		t.Errorf("Expected 'load failed|fallback failed|decode failed', received '%s'", strings.Join(msgs, "|"))
+	}
+
+	// Early exit
+	count := 0
+	err.Range(func(i int, m ErrMsg) bool {
+		count++
+		return ErrDecodingToml != m.Code()
+	})
+	if 2 != count {
+		t.Errorf("Expected 2, received %d", count)
+	}
+}
+
+func TestFrames(t *testing.T) {
+	err := New(ErrDecodingJSON, "decode failed")
+	err = Wrap(err, ErrFatal, "load failed")
+
+	frames := err.Frames()
+	if 2 != len(frames) {
+		t.Fatalf("Expected 2, received %d", len(frames))
+	}
+	if "load failed" != frames[0].Msg() || "decode failed" != frames[1].Msg() {
+		t.Errorf("Expected the most recent frame first, received '%s', '%s'", frames[0].Msg(), frames[1].Msg())
+	}
+
+	// Mutating the copy doesn't affect the stack
+	frames[0] = Msg{msg: "replaced", code: ErrUnknown}
+	frames = append(frames, Msg{msg: "appended"})
+	if 2 != err.Len() {
+		t.Errorf("Expected 2, received %d", err.Len())
+	}
+	if ErrFatal != err.Code() || "load failed" != err.Last().Msg() {
+		t.Errorf("Expected the stack to be unchanged, received '%s'", err.Last().Msg())
+	}
+}
+
+func TestJoin(t *testing.T) {
+	errRead := &os.PathError{Op: "read", Path: "config.json", Err: errors.New("read failed")}
+	errParse := Wrap(errors.New("unexpected EOF"), ErrDecodingJSON, "parse failed")
+	errWrite := New(ErrEncodingJSON, "write failed")
+
+	err := Join(errRead, nil, errParse, errWrite).(*Err)
+	if 4 != err.Len() {
+		t.Fatalf("Expected 4, received %d", err.Len())
+	}
+	if "3 errors occurred: read config.json: read failed" != err.Error() {
+		t.Errorf("Expected '3 errors occurred: read config.json: read failed', received '%s'", err.Error())
+	}
+	if ErrEncodingJSON != err.Code() {
+		t.Errorf("Expected %d, received %d", ErrEncodingJSON, err.Code())
+	}
+	if ErrUnknown != err.RootCode() {
+		t.Errorf("Expected %d, received %d", ErrUnknown, err.RootCode())
+	}
+	for _, target := range []error{errRead, ErrDecodingJSON.AsError(), ErrEncodingJSON.AsError()} {
+		if !errors.Is(err, target) {
+			t.Errorf("Expected errors.Is to match '%v'", target)
+		}
+	}
+	var pathErr *os.PathError
+	if !errors.As(err, &pathErr) || errRead != pathErr {
+		t.Errorf("Expected errors.As to find the joined *os.PathError")
+	}
+
+	// Pushing a frame replaces the summary
+	err.Push(Msg{msg: "pushed", code: ErrFatal})
+	if "pushed" != err.Error() {
+		t.Errorf("Expected 'pushed', received '%s'", err.Error())
+	}
+
+	// A single error isn't summarized
+	if "write failed" != Join(nil, errWrite).Error() {
+		t.Errorf("Expected 'write failed', received '%s'", Join(nil, errWrite).Error())
+	}
+
+	// Nothing to join
+	var e error = Join(nil, nil)
+	if nil != e {
+		t.Errorf("Expected nil, received %#v", e)
+	}
+	joinNone := func() error { return Join() }
+	if e := joinNone(); nil != e {
+		t.Errorf("Expected nil, received %#v", e)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	// Nil receivers allocate a new stack
+	var errs *Err
+	for _, e := range []error{nil, errors.New("first failed"), nil, New(ErrFatal, "second failed")} {
+		errs = errs.Append(e)
+	}
+	if nil == errs {
+		t.Fatalf("Expected an error stack")
+	}
+	if 2 != errs.Len() {
+		t.Errorf("Expected 2, received %d", errs.Len())
+	}
+	if "2 errors occurred: first failed" != errs.Error() {
+		t.Errorf("Expected '2 errors occurred: first failed', received '%s'", errs.Error())
+	}
+	if ErrFatal != errs.Code() {
+		t.Errorf("Expected %d, received %d", ErrFatal, errs.Code())
+	}
+
+	// Nothing appended
+	errs = nil
+	if nil != errs.Append(nil) {
+		t.Errorf("Expected nil")
+	}
+	appendNone := func(errs ...error) error {
+		var acc *Err
+		for _, e := range errs {
+			acc = acc.Append(e)
+		}
+		return acc.ErrOrNil()
+	}
+	if e := appendNone(); nil != e {
+		t.Errorf("Expected nil, received %#v", e)
+	}
+	if e := appendNone(nil, nil); nil != e {
+		t.Errorf("Expected nil, received %#v", e)
+	}
+	if e := appendNone(errors.New("failed")); nil == e || "failed" != e.Error() {
+		t.Errorf("Expected 'failed', received %v", e)
+	}
+
+	// An existing stack counts as one error
+	err := Wrap(errors.New("unexpected EOF"), ErrDecodingJSON, "parse failed")
+	err.Append(errors.New("close failed"))
+	if 3 != err.Len() {
+		t.Errorf("Expected 3, received %d", err.Len())
+	}
+	if "2 errors occurred: parse failed" != err.Error() {
+		t.Errorf("Expected '2 errors occurred: parse failed', received '%s'", err.Error())
+	}
+	if ErrUnknown != err.Code() {
+		t.Errorf("Expected %d, received %d", ErrUnknown, err.Code())
+	}
+}
+
+func TestErrOrNil(t *testing.T) {
+	var err *Err
+	if e := err.ErrOrNil(); nil != e {
+		t.Errorf("Expected nil, received %#v", e)
+	}
+	if e := (&Err{}).ErrOrNil(); nil != e {
+		t.Errorf("Expected nil, received %#v", e)
+	}
+	err = New(ErrFatal, "failed")
+	if e := err.ErrOrNil(); err != e {
+		t.Errorf("Expected the error stack, received %#v", e)
+	}
+}
+
+func TestNilReceiver(t *testing.T) {
+	var err *Err
+	if 0 != err.Len() {
+		t.Errorf("Expected 0, received %d", err.Len())
+	}
+	if ErrUnknown != err.Code() {
+		t.Errorf("Expected %d, received %d", ErrUnknown, err.Code())
+	}
+	if "" != err.Error() {
+		t.Errorf("Expected '', received '%s'", err.Error())
+	}
+	if nil != err.Caller() {
+		t.Errorf("Expected nil, received %v", err.Caller())
+	}
+	if 500 != err.HTTPStatus() {
+		t.Errorf("Expected 500, received %d", err.HTTPStatus())
+	}
+	if 500 != err.HTTPStatusStrict() {
+		t.Errorf("Expected 500, received %d", err.HTTPStatusStrict())
+	}
+	for _, format := range []string{"%s", "%v", "%-v", "%+v", "%#v"} {
+		if str := fmt.Sprintf(format, err); "" != str {
+			t.Errorf("Expected '' for %s, received '%s'", format, str)
+		}
+	}
+}
+
+func TestLastEmpty(t *testing.T) {
+	for _, err := range []*Err{{}, {errs: []ErrMsg{}}, nil} {
+		last := err.Last()
+		if nil == last {
+			t.Fatalf("Expected a zero Msg, received nil")
+		}
+		if ErrSuccess != last.Code() || "" != last.Msg() {
+			t.Errorf("Expected a zero Msg, received %v", last)
+		}
+	}
+}
+
+var errTestSentinel = Sentinel(errTestCode, "not found")
+
+func TestSentinel(t *testing.T) {
+	if errTestSentinel.Caller().Ok() || "" != errTestSentinel.Caller().File() {
+		t.Errorf("Expected no caller, received %s", callerText(errTestSentinel.Caller()))
+	}
+	if 0 != len(errTestSentinel.Last().Trace()) {
+		t.Errorf("Expected no trace, received %d frames", len(errTestSentinel.Last().Trace()))
+	}
+
+	err := Wrap(errTestSentinel, ErrDecodingJSON, "lookup failed")
+	err = Wrap(err, ErrFatal, "load failed")
+	err = WrapContext(err, "request failed")
+	if !errors.Is(err, errTestSentinel) {
+		t.Errorf("Expected the wrapped error to match the sentinel")
+	}
+	if errors.Is(Wrap(errors.New("other"), ErrFatal, "load failed"), errTestSentinel) {
+		t.Errorf("Expected an unrelated error not to match the sentinel")
+	}
+
+	// Wrapping doesn't modify the sentinel
+	if 1 != errTestSentinel.Len() || "not found" != errTestSentinel.Error() {
+		t.Errorf("Expected the sentinel to be unchanged, received %d frames, '%s'", errTestSentinel.Len(), errTestSentinel.Error())
+	}
+}
+
+func TestUnwrapped(t *testing.T) {
+	pathErr := &os.PathError{Op: "open", Path: "config.json", Err: os.ErrNotExist}
+	err := Wrap(pathErr, ErrDecodingJSON, "read failed")
+	err = Wrap(err, ErrFatal, "load failed")
+
+	if e, ok := err.Unwrapped().(*os.PathError); !ok || pathErr != e {
+		t.Errorf("Expected the *os.PathError, received %T", err.Unwrapped())
+	}
+	if _, ok := err.Cause().(Msg); !ok {
+		t.Errorf("Expected Cause to return the root Msg, received %T", err.Cause())
+	}
+	if nil != (&Err{}).Unwrapped() {
+		t.Errorf("Expected nil, received %v", (&Err{}).Unwrapped())
+	}
+}
+
+func TestDecodeErr(t *testing.T) {
+	setTestCode(t, errTestCode, ErrCode{Ext: "record not found", Int: "no rows", HTTP: 404})
+
+	tests := []struct {
+		err  error
+		code Code
+		msg  string
+	}{
+		{nil, ErrSuccess, "ok"},
+		{Wrap(errors.New("sql: no rows"), errTestCode, "lookup failed"), errTestCode, "record not found"},
+		// Unmapped codes never return internal text
+		{Wrap(errors.New("dial 10.0.0.1: refused"), errTestCode+1, "secret internal detail"), errTestCode + 1, "an unknown error occurred"},
+		{errors.New("secret internal detail"), ErrUnknown, "an unknown error occurred"},
+	}
+	for _, test := range tests {
+		code, msg := DecodeErr(test.err)
+		if test.code != code {
+			t.Errorf("Expected %d, received %d", test.code, code)
+		}
+		if test.msg != msg {
+			t.Errorf("Expected '%s', received '%s'", test.msg, msg)
+		}
+	}
+}
+
+func TestMessageConsistency(t *testing.T) {
+	tests := []struct {
+		err   *Err
+		index int
+	}{
+		{New(ErrFatal, "100% failed"), 0},
+		{New(ErrFatal, "%d%% failed", 100), 0},
+		{Newf(ErrFatal, "%d%% failed", 100), 0},
+		{Wrap(errors.New("cause"), ErrFatal, "100% failed"), 1},
+		{Wrapf(errors.New("cause"), ErrFatal, "%d%% failed", 100), 1},
+		{New(ErrFatal, "top").With(New(ErrUnknown, "nested"), "100% failed"), 0},
+		{New(ErrFatal, "top").With(New(ErrUnknown, "nested"), "%d%% failed", 100), 0},
+	}
+	for _, test := range tests {
+		msg := test.err.errs[test.index].(Msg)
+		if "100% failed" != msg.Msg() {
+			t.Errorf("Expected '100%% failed', received '%s'", msg.Msg())
+		}
+		if msg.Msg() != msg.Error() {
+			t.Errorf("Expected Error() and Msg() to match, received '%s' and '%s'", msg.Error(), msg.Msg())
+		}
+	}
+
+	// %w keeps the wrapped error
+	cause := errors.New("cause")
+	err := Newf(ErrFatal, "load failed: %w", cause)
+	if "load failed: cause" != err.Error() || "load failed: cause" != err.Msg() {
+		t.Errorf("Expected 'load failed: cause', received '%s' and '%s'", err.Error(), err.Msg())
+	}
+	if !errors.Is(err, cause) {
+		t.Errorf("Expected the %%w error to be wrapped")
+	}
+}
+
+func TestMaxStackDepth(t *testing.T) {
+	defer SetMaxStackDepth(MaxStackDepth())
+	SetMaxStackDepth(10)
+
+	err := New(ErrDecodingJSON, "root")
+	for a := 1; a < 1000; a++ {
+		err.Push(Msg{msg: fmt.Sprintf("frame %d", a), code: ErrFatal})
+	}
+	if 10 != err.Len() {
+		t.Fatalf("Expected 10, received %d", err.Len())
+	}
+	if "root" != err.Root().Msg() {
+		t.Errorf("Expected 'root', received '%s'", err.Root().Msg())
+	}
+	if "frame 999" != err.Last().Msg() || "frame 991" != err.errs[1].Msg() {
+		t.Errorf("Expected the most recent frames to be kept, received '%s' to '%s'", err.errs[1].Msg(), err.Last().Msg())
+	}
+
+	// Elided frames are noted in traces
+	for _, format := range []string{"%+v", "%#v", "%-v"} {
+		if str := fmt.Sprintf(format, err); !strings.Contains(str, "… 990 frames elided …") {
+			t.Errorf("Expected an elision marker for %s, received '%s'", format, str)
+		}
+	}
+	if str := fmt.Sprintf("%v", err); strings.Contains(str, "elided") {
+		t.Errorf("Expected no elision marker for %%v, received '%s'", str)
+	}
+
+	// Wrapping keeps the count
+	err = Wrap(err, ErrUnknown, "wrapped")
+	if 10 != err.Len() {
+		t.Errorf("Expected 10, received %d", err.Len())
+	}
+	if str := fmt.Sprintf("%#v", err); !strings.Contains(str, "… 991 frames elided …") {
+		t.Errorf("Expected an elision marker, received '%s'", str)
+	}
+}
+
+func TestSetMaxStackDepth(t *testing.T) {
+	defer SetMaxStackDepth(MaxStackDepth())
+
+	tests := []struct {
+		depth  int
+		expect int
+	}{
+		{10, 10},
+		{2, 2},
+		{1, 2},
+		{0, 0},
+		{-1, 0},
+	}
+	for _, test := range tests {
+		SetMaxStackDepth(test.depth)
+		if test.expect != MaxStackDepth() {
+			t.Errorf("Expected %d for %d, received %d", test.expect, test.depth, MaxStackDepth())
+		}
+	}
+
+	// Negative depths don't limit the stack
+	SetMaxStackDepth(-5)
+	err := New(ErrDecodingJSON, "root")
+	for a := 1; a < 20; a++ {
+		err.Push(Msg{msg: fmt.Sprintf("frame %d", a), code: ErrFatal})
+	}
+	if 20 != err.Len() {
+		t.Errorf("Expected 20, received %d", err.Len())
+	}
+}
+
+func TestDedup(t *testing.T) {
+	err := New(ErrDecodingJSON, "decode failed")
+	for a := 0; a < 3; a++ {
+		err = Wrap(err, ErrFatal, "retry failed")
+	}
+	line := err.Caller().Line()
+	err = Wrap(err, ErrUnknown, "gave up")
+
+	dedup := err.Dedup()
+	if 3 != dedup.Len() {
+		t.Fatalf("Expected 3, received %d", dedup.Len())
+	}
+	if 5 != err.Len() {
+		t.Errorf("Expected the original stack to be unchanged, received %d frames", err.Len())
+	}
+
+	expect := fmt.Sprintf(`#2 - caller: "err_test.go:%d:github.com/lkcloud/errors.TestDedup" error: "gave up" detail: "gave up (code:1)"
+#1 - caller: "err_test.go:%d:github.com/lkcloud/errors.TestDedup" error: "retry failed" detail: "a fatal error occurred (code:2)" ×3
+#0 - caller: "err_test.go:%d:github.com/lkcloud/errors.TestDedup" error: "decode failed" detail: "JSON data could not be decoded (code:101)"`,
+		line+3, line, line-2)
+	if str := fmt.Sprintf("%#v", dedup); expect != str {
+		t.Errorf("Expected '%s', received '%s'", expect, str)
+	}
+	if str := fmt.Sprintf("%+v", dedup); !strings.Contains(str, "#1: `github.com/lkcloud/errors.TestDedup` ×3\n") {
+		t.Errorf("Expected a repeat count, received '%s'", str)
+	}
+
+	// Deduplicating again keeps the counts
+	if str := fmt.Sprintf("%#v", dedup.Dedup()); expect != str {
+		t.Errorf("Expected '%s', received '%s'", expect, str)
+	}
+}
+
+// failWriter fails every write.
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) { return 0, io.ErrClosedPipe }
+
+func TestFprint(t *testing.T) {
+	err := Wrap(errors.New("unexpected EOF"), ErrDecodingJSON, "decode failed")
+	err = Wrap(err, ErrFatal, "load failed").WithField("path", "config.json")
+
+	for _, verb := range []string{"%v", "%+v", "%#v", "%-v", "%s", "%q", "%d"} {
+		var expect, received bytes.Buffer
+		fmt.Fprintf(&expect, verb, err)
+		if e := err.Fprint(&received, verb); nil != e {
+			t.Errorf("Expected nil for %s, received %s", verb, e)
+		}
+		if expect.String() != received.String() {
+			t.Errorf("Expected '%s' for %s, received '%s'", expect.String(), verb, received.String())
+		}
+	}
+
+	if e := err.Fprint(failWriter{}, "%+v"); io.ErrClosedPipe != e {
+		t.Errorf("Expected %v, received %v", io.ErrClosedPipe, e)
+	}
+}
+
+func largeStack() *Err {
+	err := New(ErrDecodingJSON, "decode failed")
+	for a := 0; a < 500; a++ {
+		err = Wrap(err, ErrFatal, "retry %d failed", a)
+	}
+	return err
+}
+
+func BenchmarkFprint(b *testing.B) {
+	err := largeStack()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for a := 0; a < b.N; a++ {
+		err.Fprint(ioutil.Discard, "%+v")
+	}
+}
+
+func BenchmarkFprintString(b *testing.B) {
+	err := largeStack()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for a := 0; a < b.N; a++ {
+		io.WriteString(ioutil.Discard, fmt.Sprintf("%+v", err))
+	}
+}
+
+func TestFormatQuoted(t *testing.T) {
+	err := New(ErrFatal, "field \"name\" is invalid\nsee docs")
+	if str := fmt.Sprintf("%q", err); `"field \"name\" is invalid\nsee docs"` != str {
+		t.Errorf(`Expected "field \"name\" is invalid\nsee docs", received %s`, str)
+	}
+
+	err = New(ErrFatal, "naïve value")
+	if str := fmt.Sprintf("%q", err); `"naïve value"` != str {
+		t.Errorf(`Expected "naïve value", received %s`, str)
+	}
+	if str := fmt.Sprintf("%+q", err); `"na\u00efve value"` != str {
+		t.Errorf(`Expected "na\u00efve value", received %s`, str)
+	}
+}
+
+func TestFormatWidth(t *testing.T) {
+	err := New(ErrFatal, "load failed")
+	tests := map[string]string{
+		"%20s":  "         load failed",
+		"%-20s": "load failed         ",
+		"%.10s": "load faile",
+		"%.4s":  "load",
+		"%8.4s": "    load",
+		"%35v":  "    a fatal error occurred (code:2)",
+		"%.7v":  "a fatal",
+	}
+	for format, expect := range tests {
+		if str := fmt.Sprintf(format, err); expect != str {
+			t.Errorf("Expected '%s' for %s, received '%s'", expect, format, str)
+		}
+	}
+
+	// The '-' flag selects the single-line trace for %v, not padding
+	expect := fmt.Sprintf("%-v", err)
+	if str := fmt.Sprintf("%-30v", err); expect != str {
+		t.Errorf("Expected '%s' for %%-30v, received '%s'", expect, str)
+	}
+}
+
+func TestFirstCode(t *testing.T) {
+	err := New(ErrDecodingJSON, "root decode failed")
+	err = Wrap(err, ErrFatal, "auth failed")
+	err = Wrap(err, ErrDecodingJSON, "decode failed")
+	err = Wrap(err, ErrUnknown, "request failed")
+
+	tests := []struct {
+		codes  []Code
+		msg    string
+		expect bool
+	}{
+		{[]Code{ErrDecodingJSON}, "decode failed", true},
+		{[]Code{ErrFatal}, "auth failed", true},
+		{[]Code{ErrFatal, ErrDecodingJSON}, "decode failed", true},
+		{[]Code{ErrUnknown, ErrFatal}, "request failed", true},
+		{[]Code{ErrInvalidJSON}, "", false},
+		{nil, "", false},
+	}
+	for _, test := range tests {
+		msg, ok := err.FirstCode(test.codes...)
+		if test.expect != ok {
+			t.Errorf("Expected %t for %v, received %t", test.expect, test.codes, ok)
+		} else if ok && test.msg != msg.Msg() {
+			t.Errorf("Expected '%s' for %v, received '%s'", test.msg, test.codes, msg.Msg())
+		}
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	cause := errors.New("connection refused")
+	err := Wrap(cause, ErrFatal, "dial failed")
+	caller := err.Caller()
+	err.Annotate("(attempt %d)", 3)
+
+	if 2 != err.Len() {
+		t.Errorf("Expected 2, received %d", err.Len())
+	}
+	if "dial failed (attempt 3)" != err.Msg() || "dial failed (attempt 3)" != err.Error() {
+		t.Errorf("Expected 'dial failed (attempt 3)', received '%s' and '%s'", err.Msg(), err.Error())
+	}
+	if ErrFatal != err.Code() || callerText(caller) != callerText(err.Caller()) {
+		t.Errorf("Expected the code and caller to be unchanged")
+	}
+
+	// The underlying error is kept
+	err = Wrap(cause, ErrFatal, "dial failed")
+	err.errs = err.errs[:1]
+	err.Annotate("100% failed")
+	if "connection refused 100% failed" != err.Error() {
+		t.Errorf("Expected 'connection refused 100%% failed', received '%s'", err.Error())
+	}
+	if !errors.Is(err, cause) {
+		t.Errorf("Expected the annotated error to wrap the cause")
+	}
+}
+
+func TestWithHTTPStatus(t *testing.T) {
+	setTestCode(t, errTestCode, ErrCode{Ext: "storage failed", HTTP: 500})
+
+	err := New(errTestCode, "version conflict").WithHTTPStatus(409)
+	if 409 != err.HTTPStatus() {
+		t.Errorf("Expected 409, received %d", err.HTTPStatus())
+	}
+	if 409 != err.HTTPStatusStrict() {
+		t.Errorf("Expected 409, received %d", err.HTTPStatusStrict())
+	}
+	if 500 != New(errTestCode, "version conflict").HTTPStatus() {
+		t.Errorf("Expected other errors with the code to be unaffected")
+	}
+
+	// The override is found beneath generic wraps
+	wrapped := Wrap(err, ErrUnknown, "save failed")
+	if 409 != wrapped.HTTPStatus() {
+		t.Errorf("Expected 409, received %d", wrapped.HTTPStatus())
+	}
+	if 500 != wrapped.HTTPStatusStrict() {
+		t.Errorf("Expected 500, received %d", wrapped.HTTPStatusStrict())
+	}
+}
+
+func TestSetCode(t *testing.T) {
+	err := New(ErrUnknown, "root")
+	err = Wrap(err, ErrUnknown, "wrapped")
+	if ret := err.SetCode(ErrFatal); ret != err {
+		t.Errorf("Expected SetCode to return the receiver")
+	}
+	if ErrFatal != err.Code() {
+		t.Errorf("Expected %d, received %d", ErrFatal, err.Code())
+	}
+	if ErrUnknown != err.RootCode() {
+		t.Errorf("Expected the root frame to keep %d, received %d", ErrUnknown, err.RootCode())
+	}
+
+	// An empty stack is left alone
+	empty := &Err{mux: &sync.Mutex{}}
+	if 0 != empty.SetCode(ErrFatal).Len() {
+		t.Errorf("Expected an empty stack")
+	}
+}
+
+func TestNewMsg(t *testing.T) {
+	msg := NewMsg(ErrUnknown, "%d%% failure", 100)
+	if "100% failure" != msg.Msg() {
+		t.Errorf("Expected '100%% failure', received '%s'", msg.Msg())
+	}
+	if nil == msg.Caller() || "err_test.go" != path.Base(msg.Caller().File()) {
+		t.Errorf("Expected the caller to be in err_test.go, received %v", msg.Caller())
+	}
+
+	err := New(ErrUnknown, "root")
+	err.Push(msg)
+	if "100% failure" != err.Msg() {
+		t.Errorf("Expected '100%% failure', received '%s'", err.Msg())
+	}
+
+	// Frames are copies, changing one doesn't affect the stack until it
+	// is pushed back
+	last := err.Last().SetCode(ErrFatal)
+	if ErrUnknown != err.Code() {
+		t.Errorf("Expected %d, received %d", ErrUnknown, err.Code())
+	}
+	err.Push(last)
+	if ErrFatal != err.Code() {
+		t.Errorf("Expected %d, received %d", ErrFatal, err.Code())
+	}
+
+	frame := err.Frames()[0].SetCode(ErrCodeNotFound)
+	if ErrFatal != err.Code() {
+		t.Errorf("Expected %d, received %d", ErrFatal, err.Code())
+	}
+	err.Push(frame)
+	if ErrCodeNotFound != err.Code() {
+		t.Errorf("Expected %d, received %d", ErrCodeNotFound, err.Code())
+	}
+}
+
+type uploadErr struct {
+	name string
+}
+
+func (e uploadErr) Error() string {
+	return "upload failed: " + e.name
+}
+
+func TestWrapAll(t *testing.T) {
+	sentinel := errors.New("quota exceeded")
+	failed := []error{
+		New(ErrFatal, "disk full"),
+		uploadErr{name: "b.txt"},
+		nil,
+		fmt.Errorf("c.txt: %w", sentinel),
+		Msg{code: ErrInvalidJSON, msg: "bad manifest"},
+	}
+	err := WrapAll(failed, ErrUnknown, "%d of %d uploads failed", 4, 5)
+
+	if "4 of 5 uploads failed" != err.Error() {
+		t.Errorf("Expected '4 of 5 uploads failed', received '%s'", err.Error())
+	}
+	children := err.Children()
+	if 4 != len(children) {
+		t.Fatalf("Expected 4 children, received %d", len(children))
+	}
+	if ErrFatal != children[0].Code() || "disk full" != children[0].Error() {
+		t.Errorf("Expected the first child's stack to be kept, received %v", children[0])
+	}
+	if ErrUnknown != children[1].Code() {
+		t.Errorf("Expected %d, received %d", ErrUnknown, children[1].Code())
+	}
+
+	// errors.Is matches any child
+	if !errors.Is(err, sentinel) {
+		t.Errorf("Expected a child to match the sentinel")
+	}
+	if !errors.Is(err, Msg{code: ErrInvalidJSON}) {
+		t.Errorf("Expected a child to match the code")
+	}
+	if errors.Is(err, errors.New("quota exceeded")) {
+		t.Errorf("Expected no match for an unrelated error")
+	}
+
+	// Children are still available after wrapping
+	wrapped := Wrap(err, ErrUnknown, "sync failed")
+	if 4 != len(wrapped.Children()) {
+		t.Errorf("Expected 4 children, received %d", len(wrapped.Children()))
+	}
+	if !errors.Is(wrapped, sentinel) {
+		t.Errorf("Expected the wrapped stack to match the sentinel")
+	}
+	if 0 != len(New(ErrUnknown, "new").Children()) {
+		t.Errorf("Expected no children")
+	}
+}
+
+func TestSetTraceSeparator(t *testing.T) {
+	defer SetTraceSeparator("")
+	SetTraceSeparator(" | ")
+
+	err := &Err{mux: &sync.Mutex{}}
+	for a := 0; a < 3; a++ {
+		err.Push(Msg{code: ErrFatal, msg: fmt.Sprintf("msg %d", a)})
+	}
+	frame := `#%d - caller: "<no caller>" error: "msg %d" detail: "a fatal error occurred (code:2)"`
+	expect := fmt.Sprintf(frame, 2, 2) + " | " +
+		fmt.Sprintf(frame, 1, 1) + " | " +
+		fmt.Sprintf(frame, 0, 0)
+	if str := fmt.Sprintf("%-v", err); expect != str {
+		t.Errorf("Expected '%s', received '%s'", expect, str)
+	}
+	if parts := strings.Split(fmt.Sprintf("%-v", err), " | "); 3 != len(parts) {
+		t.Errorf("Expected 3 frames, received %d", len(parts))
+	}
+
+	// Other formats are unaffected
+	if str := fmt.Sprintf("%#v", err); strings.Contains(str, " | ") {
+		t.Errorf("Expected newline separators, received '%s'", str)
+	}
+
+	SetTraceSeparator("")
+	if str := fmt.Sprintf("%-v", err); strings.Replace(expect, " | ", " ", -1) != str {
+		t.Errorf("Expected the default separator, received '%s'", str)
+	}
+}
+
+func TestErrorStack(t *testing.T) {
+	err := Wrap(New(ErrFatal, "root"), ErrUnknown, "wrapped")
+	if expect := fmt.Sprintf("%+v", err); expect != err.ErrorStack() {
+		t.Errorf("Expected '%s', received '%s'", expect, err.ErrorStack())
+	}
+	if expect := fmt.Sprintf("%#v", err); expect != err.ErrorTrace() {
+		t.Errorf("Expected '%s', received '%s'", expect, err.ErrorTrace())
+	}
+	if 2 != len(strings.Split(err.ErrorTrace(), "\n")) {
+		t.Errorf("Expected one line per frame, received '%s'", err.ErrorTrace())
+	}
+}
+
+func parseOrPanic(data map[string]int, key string) (value int, err error) {
+	defer RecoverInto(&err, ErrDecodingFailed)
+	if "" == key {
+		panic("empty key")
+	}
+	var missing map[string]int
+	if _, ok := data[key]; !ok {
+		missing[key]++
+	}
+	return data[key], nil
+}
+
+func TestRecoverInto(t *testing.T) {
+	value, err := parseOrPanic(map[string]int{"a": 1}, "a")
+	if nil != err || 1 != value {
+		t.Errorf("Expected 1 and no error, received %d and %v", value, err)
+	}
+
+	_, err = parseOrPanic(map[string]int{}, "b")
+	var e *Err
+	if !errors.As(err, &e) {
+		t.Fatalf("Expected an *Err, received %T", err)
+	}
+	if ErrDecodingFailed != e.Code() {
+		t.Errorf("Expected %d, received %d", ErrDecodingFailed, e.Code())
+	}
+	if "parseOrPanic" != path.Ext(e.Caller().Function())[1:] {
+		t.Errorf("Expected the panic location, received %s", e.Caller().Function())
+	}
+	if 0 == len(e.Last().Trace()) {
+		t.Errorf("Expected the panic stack to be captured")
+	}
+	var runtimeErr interface{ RuntimeError() }
+	if !errors.As(err, &runtimeErr) {
+		t.Errorf("Expected the runtime error to be kept")
+	}
+
+	_, err = parseOrPanic(nil, "")
+	if e, ok := err.(*Err); !ok || "panic: empty key" != e.Msg() {
+		t.Errorf("Expected 'panic: empty key', received '%v'", err)
+	}
+}
+
+func TestDefaultCode(t *testing.T) {
+	defer SetDefaultCode(DefaultCode())
+
+	err := New(ErrFatal, "root").With(errors.New("disk full"), "write failed")
+	if ErrUnknown != err.errs[0].Code() {
+		t.Errorf("Expected %d, received %d", ErrUnknown, err.errs[0].Code())
+	}
+	if ErrFatal != err.Code() {
+		t.Errorf("Expected the leading code to be unchanged, received %d", err.Code())
+	}
+	if str := fmt.Sprintf("%#v", err); strings.Contains(str, "(code:0)") {
+		t.Errorf("Expected no success codes, received '%s'", str)
+	}
+	if ErrUnknown != Wrap(errors.New("root"), ErrFatal, "wrapped").RootCode() {
+		t.Errorf("Expected %d for the wrapped error", ErrUnknown)
+	}
+
+	SetDefaultCode(ErrTypeConversionFailed)
+	err = New(ErrFatal, "root").With(errors.New("disk full"), "write failed")
+	if ErrTypeConversionFailed != err.errs[0].Code() {
+		t.Errorf("Expected %d, received %d", ErrTypeConversionFailed, err.errs[0].Code())
+	}
+}
+
+func TestReset(t *testing.T) {
+	err := Wrap(Join(New(ErrFatal, "first"), New(ErrUnknown, "second")), ErrFatal, "wrapped")
+	last := err.Last()
+	capacity := cap(err.errs)
+
+	err.Reset()
+	if 0 != err.Len() || "" != err.Error() {
+		t.Errorf("Expected an empty stack, received %d frames '%s'", err.Len(), err.Error())
+	}
+	if capacity != cap(err.errs) {
+		t.Errorf("Expected a capacity of %d, received %d", capacity, cap(err.errs))
+	}
+	if "wrapped" != last.Msg() {
+		t.Errorf("Expected earlier frames to be unaffected, received '%s'", last.Msg())
+	}
+
+	// A reset error behaves like a new one
+	err.Push(NewMsg(ErrInvalidJSON, "reused"))
+	fresh := &Err{mux: &sync.Mutex{}}
+	fresh.Push(NewMsg(ErrInvalidJSON, "reused"))
+	if fresh.Error() != err.Error() || fresh.Len() != err.Len() || fresh.Code() != err.Code() {
+		t.Errorf("Expected '%s', received '%s'", fresh.Error(), err.Error())
+	}
+}
+
+func benchmarkPool(b *testing.B, pooled bool) {
+	defer SetTraceEnabled(TraceEnabled())
+	SetTraceEnabled(false)
+	pool := sync.Pool{New: func() interface{} { return &Err{} }}
+	msg := NewMsg(ErrFatal, "failed")
+
+	b.ReportAllocs()
+	for a := 0; a < b.N; a++ {
+		var err *Err
+		if pooled {
+			err = pool.Get().(*Err)
+		} else {
+			err = &Err{}
+		}
+		err.Push(msg, msg, msg)
+		if pooled {
+			err.Reset()
+			pool.Put(err)
+		}
+	}
+}
+
+func BenchmarkPush(b *testing.B) {
+	benchmarkPool(b, false)
+}
+
+func BenchmarkPushPooled(b *testing.B) {
+	benchmarkPool(b, true)
+}
+
+func loadIf(err error) error {
+	return WrapIf(err, ErrFatal, "load failed: %s", "config")
+}
+
+func TestWrapIf(t *testing.T) {
+	if err := loadIf(nil); nil != err {
+		t.Errorf("Expected nil, received %#v", err)
+	}
+
+	err := loadIf(errors.New("read failed"))
+	e, ok := err.(*Err)
+	if !ok {
+		t.Fatalf("Expected an *Err, received %T", err)
+	}
+	if ErrFatal != e.Code() || "load failed: config" != e.Msg() || 2 != e.Len() {
+		t.Errorf("Expected the wrapped error, received %#v", e)
+	}
+	if "loadIf" != path.Ext(e.Caller().Function())[1:] {
+		t.Errorf("Expected the caller of WrapIf, received %s", e.Caller().Function())
+	}
+}
+
+func TestNewIf(t *testing.T) {
+	name := "config"
+	if err := NewIf("" == name, ErrFatal, "name required"); nil != err {
+		t.Errorf("Expected nil, received %#v", err)
+	}
+
+	name = ""
+	err := NewIf("" == name, ErrFatal, "%s required", "name")
+	e, ok := err.(*Err)
+	if !ok {
+		t.Fatalf("Expected an *Err, received %T", err)
+	}
+	if ErrFatal != e.Code() || "name required" != e.Msg() || 1 != e.Len() {
+		t.Errorf("Expected a new error, received %#v", e)
+	}
+	if "err_test.go" != path.Base(e.Caller().File()) {
+		t.Errorf("Expected the caller of NewIf, received %s", e.Caller())
+	}
+}
+
+type fieldsErr struct {
+	fields []string
+}
+
+func (e fieldsErr) Error() string {
+	return "invalid fields: " + strings.Join(e.fields, ", ")
+}
+
+func TestSameCause(t *testing.T) {
+	sentinel := errors.New("connection refused")
+	a := Wrap(Wrap(sentinel, ErrFatal, "dial failed"), ErrUnknown, "query failed")
+	b := Wrap(fmt.Errorf("retry: %w", sentinel), ErrDecodingJSON, "sync failed")
+
+	if !SameCause(a, b) {
+		t.Errorf("Expected the same cause")
+	}
+	if !SameCause(a, sentinel) || !SameCause(fmt.Errorf("wrapped: %w", sentinel), a) {
+		t.Errorf("Expected the same cause for the sentinel")
+	}
+	if SameCause(a, Wrap(errors.New("connection refused"), ErrFatal, "dial failed")) {
+		t.Errorf("Expected a different cause for a different error with the same text")
+	}
+	if SameCause(New(ErrFatal, "failed"), New(ErrFatal, "failed")) {
+		t.Errorf("Expected separately created errors to have different causes")
+	}
+
+	// Errors that aren't comparable are compared by value
+	c := Wrap(fieldsErr{[]string{"name"}}, ErrFatal, "validation failed")
+	d := Wrap(fieldsErr{[]string{"name"}}, ErrUnknown, "save failed")
+	if !SameCause(c, d) {
+		t.Errorf("Expected the same cause for equal values")
+	}
+	if SameCause(c, Wrap(fieldsErr{[]string{"email"}}, ErrFatal, "validation failed")) {
+		t.Errorf("Expected a different cause for different values")
+	}
+
+	if !SameCause(nil, nil) || SameCause(a, nil) {
+		t.Errorf("Expected nil to only match nil")
+	}
+}
@@ -0,0 +1,24 @@
+package errors_test
+
+import (
+	"fmt"
+	"sync"
+
+	errs "github.com/lkcloud/errors"
+)
+
+var errPool = sync.Pool{
+	New: func() interface{} { return &errs.Err{} },
+}
+
+func ExampleErr_Reset() {
+	// Reuse errors on hot paths that only inspect them
+	err := errPool.Get().(*errs.Err)
+	err.Push(errs.NewMsg(errs.ErrFatal, "worker failed"))
+	fmt.Printf("%s (code:%d)\n", err.Msg(), err.Code())
+
+	err.Reset()
+	errPool.Put(err)
+
+	// Output: worker failed (code:2)
+}
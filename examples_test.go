@@ -53,13 +53,13 @@ func ExampleWrap_backtrace() {
 	fmt.Printf("%+v\n\n", err)
 
 	// Output: an unknown error occurred (code:1)
-	// #4 - "failed to load configuration" examples_test.go:36 `github.com/lkcloud/errors_test.ExampleWrap_backtrace` {failed to load configuration (code:1)} #3 - "service configuration could not be loaded" mocks_test.go:30 `github.com/lkcloud/errors_test.loadConfig` {the configuration is invalid (code:1000)} #2 - "could not decode configuration data" mocks_test.go:35 `github.com/lkcloud/errors_test.decodeConfig` {could not decode configuration data (code:108)} #1 - "could not read configuration file" mocks_test.go:40 `github.com/lkcloud/errors_test.readConfig` {could not read configuration file (code:1)} #0 - "read: end of input" mocks_test.go:40 `github.com/lkcloud/errors_test.readConfig` {read: end of input (code:0)}
+	// #4 - caller: "examples_test.go:36:github.com/lkcloud/errors_test.ExampleWrap_backtrace" error: "failed to load configuration" detail: "failed to load configuration (code:1)" #3 - caller: "mocks_test.go:30:github.com/lkcloud/errors_test.loadConfig" error: "service configuration could not be loaded" detail: "the configuration is invalid (code:1000)" #2 - caller: "mocks_test.go:35:github.com/lkcloud/errors_test.decodeConfig" error: "could not decode configuration data" detail: "could not decode configuration data (code:108)" #1 - caller: "mocks_test.go:40:github.com/lkcloud/errors_test.readConfig" error: "could not read configuration file" detail: "could not read configuration file (code:1)" #0 - caller: "mocks_test.go:40:github.com/lkcloud/errors_test.readConfig" error: "read: end of input" detail: "read: end of input (code:1)"
 	//
-	// #4 - "failed to load configuration" examples_test.go:36 `github.com/lkcloud/errors_test.ExampleWrap_backtrace` {failed to load configuration (code:1)}
-	// #3 - "service configuration could not be loaded" mocks_test.go:30 `github.com/lkcloud/errors_test.loadConfig` {the configuration is invalid (code:1000)}
-	// #2 - "could not decode configuration data" mocks_test.go:35 `github.com/lkcloud/errors_test.decodeConfig` {could not decode configuration data (code:108)}
-	// #1 - "could not read configuration file" mocks_test.go:40 `github.com/lkcloud/errors_test.readConfig` {could not read configuration file (code:1)}
-	// #0 - "read: end of input" mocks_test.go:40 `github.com/lkcloud/errors_test.readConfig` {read: end of input (code:0)}
+	// #4 - caller: "examples_test.go:36:github.com/lkcloud/errors_test.ExampleWrap_backtrace" error: "failed to load configuration" detail: "failed to load configuration (code:1)"
+	// #3 - caller: "mocks_test.go:30:github.com/lkcloud/errors_test.loadConfig" error: "service configuration could not be loaded" detail: "the configuration is invalid (code:1000)"
+	// #2 - caller: "mocks_test.go:35:github.com/lkcloud/errors_test.decodeConfig" error: "could not decode configuration data" detail: "could not decode configuration data (code:108)"
+	// #1 - caller: "mocks_test.go:40:github.com/lkcloud/errors_test.readConfig" error: "could not read configuration file" detail: "could not read configuration file (code:1)"
+	// #0 - caller: "mocks_test.go:40:github.com/lkcloud/errors_test.readConfig" error: "read: end of input" detail: "read: end of input (code:1)"
 	//
 	// #4: `github.com/lkcloud/errors_test.ExampleWrap_backtrace`
 	//	error:   failed to load configuration
@@ -84,8 +84,8 @@ func ExampleWrap_backtrace() {
 	// #0: `github.com/lkcloud/errors_test.readConfig`
 	//	error:   read: end of input
 	//	line:    mocks_test.go:40
-	//	detail:  read: end of input (code:0)
-	//	message: success (code:0)
+	//	detail:  read: end of input (code:1)
+	//	message: an unknown error occurred (code:1)
 }
 
 func ExampleFrom() {
@@ -119,7 +119,7 @@ func ExampleErr_With() {
 	// Output: Configuration not valid (code:1000)
 }
 
-func ExampleDetail() {
+func ExampleErr_Detail() {
 	err := loadConfig()
 	if nil != err {
 		err = errs.Wrap(err, 1, "failed to load configuration")
@@ -132,7 +132,7 @@ func ExampleDetail() {
 	// Output: failed to load configuration
 }
 
-func ExampleHTTPStatus() {
+func ExampleErr_HTTPStatus() {
 	err := loadConfig()
 	if nil != err {
 		err = errs.Wrap(err, ConfigurationNotValid, "failed to load configuration")
@@ -0,0 +1,23 @@
+package errors_test
+
+import (
+	"testing"
+
+	errs "github.com/lkcloud/errors"
+)
+
+// From must store the frame returned by SetCode, since Msg has value
+// receivers and SetCode returns a modified copy.
+func TestFromSetsCode(t *testing.T) {
+	existing := decodeConfig().(*errs.Err)
+	err := errs.From(ConfigurationNotValid, existing)
+	if ConfigurationNotValid != err.Code() {
+		t.Errorf("Expected %d, received %d", ConfigurationNotValid, err.Code())
+	}
+	if ConfigurationNotValid != err.Last().Code() {
+		t.Errorf("Expected %d, received %d", ConfigurationNotValid, err.Last().Code())
+	}
+	if errs.ErrInvalidJSON != existing.Code() {
+		t.Errorf("Expected %d, received %d", errs.ErrInvalidJSON, existing.Code())
+	}
+}
@@ -0,0 +1,125 @@
+package errors
+
+import (
+	"net/http"
+)
+
+// GRPCCode defines a gRPC status code. Values match the codes defined in
+// google.golang.org/grpc/codes, so a GRPCCode can be converted with
+// codes.Code(c) without this package depending on gRPC.
+type GRPCCode uint32
+
+// gRPC status codes
+const (
+	GRPCOK GRPCCode = iota
+	GRPCCanceled
+	GRPCUnknown
+	GRPCInvalidArgument
+	GRPCDeadlineExceeded
+	GRPCNotFound
+	GRPCAlreadyExists
+	GRPCPermissionDenied
+	GRPCResourceExhausted
+	GRPCFailedPrecondition
+	GRPCAborted
+	GRPCOutOfRange
+	GRPCUnimplemented
+	GRPCInternal
+	GRPCUnavailable
+	GRPCDataLoss
+	GRPCUnauthenticated
+)
+
+// GRPCCoder defines an optional interface for Coder implementations that
+// map an error code to a gRPC status code.
+type GRPCCoder interface {
+	// gRPC status that should be used for the associated error code.
+	GRPCStatus() GRPCCode
+}
+
+// GRPCStatus returns the gRPC status code of the frame HTTPStatus() takes
+// its status from. If the code of that frame sets an explicit gRPC status,
+// it is returned. Otherwise, the status is derived from HTTPStatus(), so
+// the HTTP and gRPC mappings of an error agree.
+func (err *Err) GRPCStatus() GRPCCode {
+	status, frame := err.statusFrame()
+	if nil != frame {
+		if code, ok := LookupCode(frame.Code()); ok {
+			if status, ok := explicitGRPCStatus(code); ok {
+				return status
+			}
+		}
+	}
+	return grpcFromHTTP(status)
+}
+
+// explicitGRPCStatus returns the gRPC status of c, reporting false if c
+// doesn't implement GRPCCoder or the status wasn't set. Coders that
+// implement HasGRPCStatus() bool can report an unset status.
+func explicitGRPCStatus(c Coder) (GRPCCode, bool) {
+	coder, ok := c.(GRPCCoder)
+	if !ok {
+		return 0, false
+	}
+	if c, ok := c.(interface{ HasGRPCStatus() bool }); ok && !c.HasGRPCStatus() {
+		return 0, false
+	}
+	return coder.GRPCStatus(), true
+}
+
+// GRPCStatus implements GRPCCoder. GRPCStatus returns the associated gRPC
+// status code, if any. Otherwise, the status is derived from HTTPStatus().
+func (code ErrCode) GRPCStatus() GRPCCode {
+	if 0 == code.GRPC {
+		return grpcFromHTTP(code.HTTPStatus())
+	}
+	return code.GRPC
+}
+
+// HasGRPCStatus reports whether a gRPC status was set for the code, so an
+// explicit status can be told apart from the one derived by GRPCStatus.
+func (code ErrCode) HasGRPCStatus() bool {
+	return 0 != code.GRPC
+}
+
+// grpcFromHTTP maps an HTTP status to the closest gRPC status code.
+func grpcFromHTTP(status int) GRPCCode {
+	switch status {
+	case http.StatusBadRequest:
+		return GRPCInvalidArgument
+	case http.StatusUnauthorized:
+		return GRPCUnauthenticated
+	case http.StatusForbidden:
+		return GRPCPermissionDenied
+	case http.StatusNotFound:
+		return GRPCNotFound
+	case http.StatusConflict:
+		return GRPCAlreadyExists
+	case http.StatusPreconditionFailed:
+		return GRPCFailedPrecondition
+	case http.StatusRequestedRangeNotSatisfiable:
+		return GRPCOutOfRange
+	case http.StatusTooManyRequests:
+		return GRPCResourceExhausted
+	case 499:
+		return GRPCCanceled
+	case http.StatusInternalServerError:
+		return GRPCInternal
+	case http.StatusNotImplemented:
+		return GRPCUnimplemented
+	case http.StatusServiceUnavailable:
+		return GRPCUnavailable
+	case http.StatusGatewayTimeout:
+		return GRPCDeadlineExceeded
+	}
+
+	switch {
+	case status >= 200 && status < 300:
+		return GRPCOK
+	case status >= 400 && status < 500:
+		return GRPCFailedPrecondition
+	case status >= 500 && status < 600:
+		return GRPCInternal
+	}
+	return GRPCUnknown
+}
@@ -0,0 +1,76 @@
+package errors
+
+import (
+	"testing"
+)
+
+func TestGRPCStatus(t *testing.T) {
+	tests := []struct {
+		code   ErrCode
+		expect GRPCCode
+	}{
+		// Explicit gRPC codes
+		{ErrCode{HTTP: 500, GRPC: GRPCUnavailable}, GRPCUnavailable},
+		{ErrCode{GRPC: GRPCDataLoss}, GRPCDataLoss},
+		// Fallback from the HTTP status
+		{ErrCode{HTTP: 400}, GRPCInvalidArgument},
+		{ErrCode{HTTP: 404}, GRPCNotFound},
+		{ErrCode{HTTP: 418}, GRPCFailedPrecondition},
+		{ErrCode{HTTP: 500}, GRPCInternal},
+		{ErrCode{HTTP: 502}, GRPCInternal},
+		{ErrCode{HTTP: 503}, GRPCUnavailable},
+	}
+	for _, test := range tests {
+		setTestCode(t, errTestCode, test.code)
+		err := New(errTestCode, "grpc")
+		if status := err.GRPCStatus(); test.expect != status {
+			t.Errorf("Expected %d for %+v, received %d", test.expect, test.code, status)
+		}
+	}
+}
+
+func TestGRPCStatusWrapped(t *testing.T) {
+	setTestCode(t, errTestCode, ErrCode{Ext: "not found", HTTP: 404})
+	setTestCode(t, errTestCode+1, ErrCode{Ext: "unavailable", HTTP: 500, GRPC: GRPCUnavailable})
+
+	tests := []struct {
+		err    *Err
+		http   int
+		expect GRPCCode
+	}{
+		{Wrap(New(errTestCode, "missing"), ErrUnknown, "lookup failed"), 404, GRPCNotFound},
+		{Wrap(New(errTestCode, "missing"), ErrFatal, "lookup failed"), 404, GRPCNotFound},
+		{Wrap(New(errTestCode, "missing"), errTestCode+1, "lookup failed"), 500, GRPCUnavailable},
+		{Wrap(New(errTestCode+1, "down"), ErrUnknown, "lookup failed"), 500, GRPCUnavailable},
+		{Wrap(New(ErrUnknown, "failed"), ErrFatal, "lookup failed"), 500, GRPCInternal},
+	}
+	for k, test := range tests {
+		if status := test.err.HTTPStatus(); test.http != status {
+			t.Errorf("Expected HTTP status %d for test %d, received %d", test.http, k, status)
+		}
+		if status := test.err.GRPCStatus(); test.expect != status {
+			t.Errorf("Expected gRPC status %d for test %d, received %d", test.expect, k, status)
+		}
+	}
+}
+
+func TestGRPCStatusHTTPOverride(t *testing.T) {
+	setTestCode(t, errTestCode, ErrCode{Ext: "unavailable", GRPC: GRPCUnavailable})
+
+	err := New(ErrUnknown, "conflict").WithHTTPStatus(409)
+	if GRPCAlreadyExists != err.GRPCStatus() {
+		t.Errorf("Expected %d, received %d", GRPCAlreadyExists, err.GRPCStatus())
+	}
+
+	// The override of an outer frame wins over a deeper status
+	err = Wrap(err, ErrUnknown, "update failed").WithHTTPStatus(400)
+	if GRPCInvalidArgument != err.GRPCStatus() {
+		t.Errorf("Expected %d, received %d", GRPCInvalidArgument, err.GRPCStatus())
+	}
+
+	// An explicit gRPC status of the code is kept
+	err = New(errTestCode, "down").WithHTTPStatus(409)
+	if GRPCUnavailable != err.GRPCStatus() {
+		t.Errorf("Expected %d, received %d", GRPCUnavailable, err.GRPCStatus())
+	}
+}
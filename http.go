@@ -0,0 +1,158 @@
+package errors
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// httpErr defines the JSON response body written by WriteHTTP.
+type httpErr struct {
+	// Leading error code.
+	Code Code `json:"code"`
+	// External (user) facing error text for the leading code.
+	Message string `json:"message"`
+}
+
+/*
+WriteHTTP writes err to w as a JSON error response.
+
+The response status is the value of HTTPStatus() and the body contains
+the leading error code and its external error text:
+
+	{"code":1000,"message":"the configuration is not valid"}
+
+The status, code and message are the values returned by StatusFromError,
+so errors that are not an *Err are written as ErrUnknown with a 500
+status.
+See StatusFromError.
+*/
+func WriteHTTP(w http.ResponseWriter, err error) {
+	status, code, message := StatusFromError(err)
+	out := httpErr{Code: code, Message: message}
+
+	body, _ := json.Marshal(out)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+/*
+StatusFromError returns the HTTP status, leading code and external error
+text that should be used to report err:
+
+  - nil returns a 200 status, ErrSuccess and its text.
+  - An *Err returns its HTTPStatus(), Code() and the external text for the
+    code. If the code has no external text, the default message for the
+    status is used, then the external text for ErrUnknown. Frame messages
+    and the Int text of codes aren't used.
+  - Any other error returns a 500 status, ErrUnknown and its text.
+*/
+func StatusFromError(err error) (httpStatus int, code Code, message string) {
+	httpStatus = http.StatusInternalServerError
+	if nil == err {
+		httpStatus = http.StatusOK
+	} else if e, ok := err.(*Err); ok {
+		httpStatus = e.HTTPStatus()
+	}
+	code = CodeOf(err)
+	return httpStatus, code, externalMessage(code, httpStatus)
+}
+
+// problem defines an RFC 7807 problem details response body.
+type problem struct {
+	// URI reference identifying the problem type.
+	Type string `json:"type"`
+	// External (user) facing error text for the leading code.
+	Title string `json:"title"`
+	// HTTP status of the response.
+	Status int `json:"status"`
+	// User-safe explanation of this occurrence of the problem.
+	Detail string `json:"detail"`
+	// Leading error code.
+	Code Code `json:"code"`
+}
+
+/*
+ProblemJSON returns err as an RFC 7807 problem details document:
+
+	{"type":"about:blank","title":"the configuration is not valid","status":500,"detail":"the configuration is not valid","code":1000}
+
+The status, code and title are the values returned by StatusFromError.
+The detail repeats the title rather than describing the frames of err,
+since problem documents are returned to clients.
+*/
+func ProblemJSON(err error) []byte {
+	status, code, message := StatusFromError(err)
+	body, _ := json.Marshal(problem{
+		Type:   "about:blank",
+		Title:  message,
+		Status: status,
+		Detail: message,
+		Code:   code,
+	})
+	return body
+}
+
+// WriteProblem writes err to w as an application/problem+json response
+// with the status and body described by ProblemJSON.
+func WriteProblem(w http.ResponseWriter, err error) {
+	status, _, _ := StatusFromError(err)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	w.Write(ProblemJSON(err))
+}
+
+// IsClientError reports whether err should be reported with a 4xx HTTP
+// status, using the status returned by StatusFromError.
+func IsClientError(err error) bool {
+	status, _, _ := StatusFromError(err)
+	return status >= 400 && status < 500
+}
+
+// IsServerError reports whether err should be reported with a 5xx HTTP
+// status, using the status returned by StatusFromError. Errors that aren't
+// an *Err are server errors.
+func IsServerError(err error) bool {
+	status, _, _ := StatusFromError(err)
+	return status >= 500 && status < 600
+}
+
+// externalMessage returns user-safe error text for code, falling back to
+// the default message for status and then to the text for ErrUnknown.
+func externalMessage(code Code, status int) string {
+	if coder, ok := LookupCode(code); ok && "" != coder.String() {
+		return coder.String()
+	}
+	if msg, ok := defaultMessageForStatus(status); ok {
+		return msg
+	}
+	if coder, ok := LookupCode(ErrUnknown); ok {
+		return coder.String()
+	}
+	return ""
+}
+
+/*
+RecoverMiddleware returns a handler that recovers panics in next.
+
+The recovered value is converted into an *Err with the ErrFatal code and
+the call stack of the panic, logged using the %+v format, and written to
+the response with WriteHTTP. http.ErrAbortHandler is not recovered so
+that the server can abort the response as intended.
+*/
+func RecoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if v := recover(); nil != v {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				err := recoverErr(v, ErrFatal)
+				log.Printf("%+v", err)
+				WriteHTTP(w, err)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,174 @@
+package errors
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestWriteHTTP(t *testing.T) {
+	setTestCode(t, errTestCode, ErrCode{Ext: "record not found", Int: "no rows in result set", HTTP: 404})
+
+	tests := []struct {
+		err    error
+		status int
+		body   string
+	}{
+		// Registered codes use the external text
+		{
+			Wrap(errors.New("sql: no rows"), errTestCode, "user lookup failed"),
+			404,
+			`{"code":9000,"message":"record not found"}`,
+		},
+		// Codes without external text never leak the error message
+		{
+			New(errTestCode+1, "secret internal detail"),
+			500,
+			`{"code":9001,"message":"an unknown error occurred"}`,
+		},
+		// Other errors are unknown
+		{
+			errors.New("secret internal detail"),
+			500,
+			`{"code":1,"message":"an unknown error occurred"}`,
+		},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		WriteHTTP(w, test.err)
+		if test.status != w.Code {
+			t.Errorf("Expected %d, received %d", test.status, w.Code)
+		}
+		if "application/json" != w.Header().Get("Content-Type") {
+			t.Errorf("Expected 'application/json', received '%s'", w.Header().Get("Content-Type"))
+		}
+		if test.body != w.Body.String() {
+			t.Errorf("Expected %s, received %s", test.body, w.Body.String())
+		}
+	}
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	handler := RecoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if 500 != w.Code {
+		t.Errorf("Expected 500, received %d", w.Code)
+	}
+	if `{"code":2,"message":"a fatal error occurred"}` != w.Body.String() {
+		t.Errorf(`Expected {"code":2,"message":"a fatal error occurred"}, received %s`, w.Body.String())
+	}
+	if !strings.Contains(buf.String(), "panic: handler failed") {
+		t.Errorf("Expected the panic to be logged, received '%s'", buf.String())
+	}
+	if !strings.Contains(buf.String(), "http_test.go:") {
+		t.Errorf("Expected the panic location to be logged, received '%s'", buf.String())
+	}
+}
+
+func TestRecoverErr(t *testing.T) {
+	var err *Err
+	func() {
+		defer func() {
+			err = recoverErr(recover(), ErrFatal)
+		}()
+		var m map[string]int
+		m["panic"]++
+	}()
+
+	if ErrFatal != err.Code() {
+		t.Errorf("Expected %d, received %d", ErrFatal, err.Code())
+	}
+	if "http_test.go" != path.Base(err.Caller().File()) {
+		t.Errorf("Expected 'http_test.go', received '%s'", path.Base(err.Caller().File()))
+	}
+	if 0 == len(err.Last().Trace()) {
+		t.Errorf("Expected a trace to be captured")
+	}
+}
+
+func TestStatusFromError(t *testing.T) {
+	setTestCode(t, errTestCode, ErrCode{Ext: "storage unavailable", Int: "disk full", HTTP: 500})
+
+	tests := []struct {
+		err     error
+		status  int
+		code    Code
+		message string
+	}{
+		{nil, 200, ErrSuccess, "ok"},
+		{Wrap(errors.New("write: no space left"), errTestCode, "save failed"), 500, errTestCode, "storage unavailable"},
+		{errors.New("secret internal detail"), 500, ErrUnknown, "an unknown error occurred"},
+	}
+	for _, test := range tests {
+		status, code, message := StatusFromError(test.err)
+		if test.status != status || test.code != code || test.message != message {
+			t.Errorf("Expected %d, %d, '%s', received %d, %d, '%s'", test.status, test.code, test.message, status, code, message)
+		}
+	}
+}
+
+func TestIsClientError(t *testing.T) {
+	setTestCode(t, errTestCode, ErrCode{Ext: "record not found", HTTP: 404})
+
+	tests := []struct {
+		err    error
+		client bool
+		server bool
+	}{
+		{New(errTestCode, "not found"), true, false},
+		{New(ErrFatal, "fatal"), false, true},
+		{errors.New("plain"), false, true},
+		{New(ErrSuccess, "ok"), false, false},
+		{nil, false, false},
+	}
+	for k, test := range tests {
+		if client := IsClientError(test.err); test.client != client {
+			t.Errorf("Expected IsClientError %t at %d, received %t", test.client, k, client)
+		}
+		if server := IsServerError(test.err); test.server != server {
+			t.Errorf("Expected IsServerError %t at %d, received %t", test.server, k, server)
+		}
+	}
+}
+
+func TestWriteProblem(t *testing.T) {
+	setTestCode(t, errTestCode, ErrCode{Ext: "record not found", Int: "no rows in result set", HTTP: 404})
+
+	err := Wrap(errors.New("sql: no rows"), errTestCode, "user lookup failed")
+	expect := `{"type":"about:blank","title":"record not found","status":404,"detail":"record not found","code":9000}`
+	if body := string(ProblemJSON(err)); expect != body {
+		t.Errorf("Expected '%s', received '%s'", expect, body)
+	}
+
+	rec := httptest.NewRecorder()
+	WriteProblem(rec, err)
+	if 404 != rec.Code {
+		t.Errorf("Expected 404, received %d", rec.Code)
+	}
+	if "application/problem+json" != rec.Header().Get("Content-Type") {
+		t.Errorf("Expected 'application/problem+json', received '%s'", rec.Header().Get("Content-Type"))
+	}
+	if expect != rec.Body.String() {
+		t.Errorf("Expected '%s', received '%s'", expect, rec.Body.String())
+	}
+
+	// Internal text is never written
+	body := string(ProblemJSON(New(errTestCode+1, "secret internal detail")))
+	if strings.Contains(body, "secret") || !strings.Contains(body, `"status":500`) {
+		t.Errorf("Expected a safe 500 problem, received '%s'", body)
+	}
+}
@@ -0,0 +1,151 @@
+package errors
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// jsonErr defines the JSON representation of an error stack.
+type jsonErr struct {
+	// Leading error code.
+	Code Code `json:"code"`
+	// External (user) facing error text for the leading code.
+	Message string `json:"message"`
+	// HTTP status associated with the leading code.
+	HTTPStatus int `json:"http_status"`
+	// Structured metadata merged from all frames.
+	Fields map[string]interface{} `json:"fields,omitempty"`
+	// Error frames, most recent first.
+	Frames []jsonFrame `json:"frames"`
+}
+
+// jsonFrame defines the JSON representation of a single error frame.
+type jsonFrame struct {
+	Index   int                    `json:"index"`
+	Error   string                 `json:"error"`
+	Caller  string                 `json:"caller"`
+	Code    Code                   `json:"code"`
+	Detail  string                 `json:"detail"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// jsonCaller defines the JSON representation of a caller in a trace.
+type jsonCaller struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Function string `json:"function"`
+}
+
+// MarshalJSON implements json.Marshaler, encoding the trace as an array of
+// objects with file, line and function properties. Files and functions
+// are shown as in formatted output, see SetTrimPrefix and
+// SetShortFuncNames.
+func (trace Trace) MarshalJSON() ([]byte, error) {
+	out := make([]jsonCaller, 0, len(trace))
+	for _, caller := range trace {
+		if nil == caller {
+			out = append(out, jsonCaller{})
+			continue
+		}
+		out = append(out, jsonCaller{
+			File:     callerFile(caller),
+			Line:     caller.Line(),
+			Function: funcName(caller),
+		})
+	}
+	return json.Marshal(out)
+}
+
+// JSON returns the JSON representation of the error stack described by
+// MarshalJSON. It is also available with the %+#v format.
+func (err *Err) JSON() ([]byte, error) {
+	return json.Marshal(err)
+}
+
+/*
+MarshalJSON implements json.Marshaler.
+
+The top-level code, message and http_status properties describe the
+leading error and are safe for external consumption. The fields property
+contains structured metadata merged from all frames and is omitted if no
+fields are attached.
+
+The frames property lists each error in the stack, most recent first,
+using the same data as the %#v format along with any fields attached to
+the frame. Frames contain internal error text and are intended for logs
+only; the message of a frame whose code isn't registered falls back to
+its error text.
+*/
+func (err *Err) MarshalJSON() ([]byte, error) {
+	out := jsonErr{
+		Code:       err.Code(),
+		Message:    err.externalText(),
+		HTTPStatus: err.HTTPStatus(),
+		Fields:     err.Fields(),
+		Frames:     []jsonFrame{},
+	}
+
+	errs := err.stack()
+	for k := len(errs) - 1; k >= 0; k-- {
+		msg := errs[k]
+		detail, message := frameText(msg)
+		out.Frames = append(out.Frames, jsonFrame{
+			Index:   k,
+			Error:   msg.Msg(),
+			Caller:  callerText(msg.Caller()),
+			Code:    msg.Code(),
+			Detail:  detail,
+			Message: message,
+			Fields:  frameFields(msg),
+		})
+	}
+
+	return json.Marshal(out)
+}
+
+/*
+UnmarshalJSON implements json.Unmarshaler, rebuilding an error stack from
+the document produced by MarshalJSON. Frame codes, messages, fields and
+caller files, line numbers and function names are restored. Program
+counters can't be recovered so restored callers report Ok() == false.
+*/
+func (err *Err) UnmarshalJSON(data []byte) error {
+	var in jsonErr
+	if e := json.Unmarshal(data, &in); nil != e {
+		return e
+	}
+
+	errs := make([]ErrMsg, 0, len(in.Frames))
+	for k := len(in.Frames) - 1; k >= 0; k-- {
+		frame := in.Frames[k]
+		errs = append(errs, Msg{
+			err:    errors.New(frame.Error),
+			caller: parseCallerText(frame.Caller),
+			code:   frame.Code,
+			msg:    frame.Error,
+			fields: frame.Fields,
+		})
+	}
+
+	// Join and elision state describes the previous stack
+	err.Lock()
+	err.errs = errs
+	err.joined, err.first, err.elided = 0, "", 0
+	err.Unlock()
+	return nil
+}
+
+// parseCallerText parses the output of callerText. The program counter
+// can't be recovered so the returned caller is never Ok. Text that can't
+// be parsed is kept as the file name.
+func parseCallerText(str string) Call {
+	var call Call
+	if noCaller == str {
+		return call
+	}
+	if nil != call.UnmarshalText([]byte(str)) {
+		call = Call{file: str}
+	}
+	return call
+}
@@ -0,0 +1,330 @@
+package errors
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// errTestCode is registered by tests that need custom code metadata.
+const errTestCode Code = 9000
+
+// setTestCode sets c as the metadata for code until the test completes.
+func setTestCode(t *testing.T, code Code, c Coder) {
+	t.Helper()
+	if err := SetCode(code, c); nil != err {
+		t.Fatalf("Expected nil, received %s", err)
+	}
+	cleanupCodes(t, code)
+}
+
+// cleanupCodes removes the metadata registered for codes when the test
+// completes.
+func cleanupCodes(t *testing.T, codes ...Code) {
+	t.Cleanup(func() {
+		for _, code := range codes {
+			deleteCode(code)
+		}
+	})
+}
+
+// deleteCode removes the metadata registered for code.
+func deleteCode(code Code) {
+	codesMux.Lock()
+	delete(Codes, code)
+	codesMux.Unlock()
+}
+
+func TestMarshalJSON(t *testing.T) {
+	err := &Err{
+		errs: []ErrMsg{
+			Msg{
+				err:    errors.New("read: end of input"),
+				caller: Call{file: "/src/config/read.go", line: 12, ok: true},
+				code:   0,
+				msg:    "read: end of input",
+			},
+			Msg{
+				err:    errors.New("could not decode configuration data"),
+				caller: Call{file: "/src/config/decode.go", line: 30, ok: true},
+				code:   ErrDecodingJSON,
+				msg:    "could not decode configuration data",
+			},
+		},
+	}
+
+	golden := `{
+		"code": 101,
+		"message": "JSON data could not be decoded",
+		"http_status": 500,
+		"frames": [
+			{
+				"index": 1,
+				"error": "could not decode configuration data",
+				"caller": "decode.go:30:",
+				"code": 101,
+				"detail": "JSON data could not be decoded",
+				"message": "JSON data could not be decoded"
+			},
+			{
+				"index": 0,
+				"error": "read: end of input",
+				"caller": "read.go:12:",
+				"code": 0,
+				"detail": "ok",
+				"message": "ok"
+			}
+		]
+	}`
+	expect := bytes.NewBuffer([]byte{})
+	if e := json.Compact(expect, []byte(golden)); nil != e {
+		t.Fatalf("invalid golden document: %s", e)
+	}
+
+	data, e := json.Marshal(err)
+	if nil != e {
+		t.Fatalf("Expected nil, received %s", e)
+	}
+	if expect.String() != string(data) {
+		t.Errorf("Expected %s, received %s", expect.String(), string(data))
+	}
+
+	// The marshaled document decodes back into the same structure
+	var out jsonErr
+	if e := json.Unmarshal(data, &out); nil != e {
+		t.Fatalf("Expected nil, received %s", e)
+	}
+	if err.Code() != out.Code {
+		t.Errorf("Expected %d, received %d", err.Code(), out.Code)
+	}
+	if 2 != len(out.Frames) {
+		t.Fatalf("Expected 2 frames, received %d", len(out.Frames))
+	}
+	if "read: end of input" != out.Frames[1].Error {
+		t.Errorf("Expected 'read: end of input', received '%s'", out.Frames[1].Error)
+	}
+}
+
+func TestMarshalJSONExternalMessage(t *testing.T) {
+	setTestCode(t, errTestCode, ErrCode{Ext: "invalid input", Int: "user id 42 failed validation", HTTP: 400})
+
+	data, e := json.Marshal(New(errTestCode, "validation failed"))
+	if nil != e {
+		t.Fatalf("Expected nil, received %s", e)
+	}
+	var out jsonErr
+	if e := json.Unmarshal(data, &out); nil != e {
+		t.Fatalf("Expected nil, received %s", e)
+	}
+	if "invalid input" != out.Message {
+		t.Errorf("Expected 'invalid input', received '%s'", out.Message)
+	}
+	if 400 != out.HTTPStatus {
+		t.Errorf("Expected 400, received %d", out.HTTPStatus)
+	}
+}
+
+func TestMarshalJSONUnregisteredCode(t *testing.T) {
+	err := &Err{
+		errs: []ErrMsg{
+			Msg{
+				err:    errors.New("select * from users where pw=hunter2"),
+				caller: Call{file: "/src/users/find.go", line: 8, ok: true},
+				code:   4242,
+				msg:    "select * from users where pw=hunter2",
+			},
+		},
+	}
+
+	golden := `{
+		"code": 4242,
+		"message": "an unknown error occurred",
+		"http_status": 500,
+		"frames": [
+			{
+				"index": 0,
+				"error": "select * from users where pw=hunter2",
+				"caller": "find.go:8:",
+				"code": 4242,
+				"detail": "select * from users where pw=hunter2",
+				"message": "select * from users where pw=hunter2"
+			}
+		]
+	}`
+	expect := bytes.NewBuffer([]byte{})
+	if e := json.Compact(expect, []byte(golden)); nil != e {
+		t.Fatalf("invalid golden document: %s", e)
+	}
+
+	data, e := json.Marshal(err)
+	if nil != e {
+		t.Fatalf("Expected nil, received %s", e)
+	}
+	if expect.String() != string(data) {
+		t.Errorf("Expected %s, received %s", expect.String(), string(data))
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	err := Wrap(errors.New("read: end of input"), ErrDecodingJSON, "could not decode configuration data")
+	err = Wrap(err, ErrFatal, "could not load configuration")
+
+	data, e := json.Marshal(err)
+	if nil != e {
+		t.Fatalf("Expected nil, received %s", e)
+	}
+
+	restored := &Err{}
+	if e := json.Unmarshal(data, restored); nil != e {
+		t.Fatalf("Expected nil, received %s", e)
+	}
+	if err.Len() != restored.Len() {
+		t.Fatalf("Expected %d frames, received %d", err.Len(), restored.Len())
+	}
+	for k, msg := range err.errs {
+		if msg.Code() != restored.errs[k].Code() {
+			t.Errorf("Expected code %d at %d, received %d", msg.Code(), k, restored.errs[k].Code())
+		}
+		if msg.Msg() != restored.errs[k].Msg() {
+			t.Errorf("Expected '%s' at %d, received '%s'", msg.Msg(), k, restored.errs[k].Msg())
+		}
+	}
+
+	caller := restored.Caller()
+	if caller.Ok() {
+		t.Errorf("Expected a restored caller to not be Ok")
+	}
+	if "json_test.go" != caller.File() {
+		t.Errorf("Expected 'json_test.go', received '%s'", caller.File())
+	}
+	if err.Caller().Line() != caller.Line() {
+		t.Errorf("Expected %d, received %d", err.Caller().Line(), caller.Line())
+	}
+
+	if e := json.Unmarshal([]byte(`{"frames":"invalid"}`), &Err{}); nil == e {
+		t.Errorf("Expected an error decoding an invalid document")
+	}
+}
+
+func TestUnmarshalJSONReuse(t *testing.T) {
+	defer SetMaxStackDepth(MaxStackDepth())
+	SetMaxStackDepth(2)
+
+	data, e := json.Marshal(Wrap(New(ErrDecodingJSON, "decode failed"), ErrFatal, "load failed"))
+	if nil != e {
+		t.Fatalf("Expected nil, received %s", e)
+	}
+
+	// A receiver with join and elision state
+	err := Join(New(ErrUnknown, "first failed"), New(ErrUnknown, "second failed"), New(ErrUnknown, "third failed")).(*Err)
+	if !strings.Contains(fmt.Sprintf("%#v", err), "elided") {
+		t.Fatalf("Expected elided frames, received '%#v'", err)
+	}
+	if e := json.Unmarshal(data, err); nil != e {
+		t.Fatalf("Expected nil, received %s", e)
+	}
+	if "load failed" != err.Error() {
+		t.Errorf("Expected 'load failed', received '%s'", err.Error())
+	}
+	if str := fmt.Sprintf("%#v", err); strings.Contains(str, "elided") {
+		t.Errorf("Expected no elided frames, received '%s'", str)
+	}
+	if 2 != err.Len() || ErrFatal != err.Code() || ErrDecodingJSON != err.RootCode() {
+		t.Errorf("Expected the decoded stack, received '%#v'", err)
+	}
+}
+
+func TestMarshalJSONFields(t *testing.T) {
+	err := New(ErrFatal, "root").WithField("user_id", 42)
+	err = Wrap(err, ErrFatal, "wrapped")
+
+	data, e := json.Marshal(err)
+	if nil != e {
+		t.Fatalf("Expected nil, received %s", e)
+	}
+
+	var out map[string]interface{}
+	if e := json.Unmarshal(data, &out); nil != e {
+		t.Fatalf("Expected nil, received %s", e)
+	}
+	if fields, ok := out["fields"].(map[string]interface{}); !ok || float64(42) != fields["user_id"] {
+		t.Errorf("Expected merged fields, received %v", out["fields"])
+	}
+
+	frames := out["frames"].([]interface{})
+	if _, ok := frames[0].(map[string]interface{})["fields"]; ok {
+		t.Errorf("Expected fields to be omitted from a frame without fields")
+	}
+	if _, ok := frames[1].(map[string]interface{})["fields"]; !ok {
+		t.Errorf("Expected fields on the root frame")
+	}
+
+	restored := &Err{}
+	if e := json.Unmarshal(data, restored); nil != e {
+		t.Fatalf("Expected nil, received %s", e)
+	}
+	if float64(42) != restored.Fields()["user_id"] {
+		t.Errorf("Expected 42, received %v", restored.Fields()["user_id"])
+	}
+
+	data, _ = json.Marshal(New(ErrFatal, "no fields"))
+	out = map[string]interface{}{}
+	_ = json.Unmarshal(data, &out)
+	if _, ok := out["fields"]; ok {
+		t.Errorf("Expected fields to be omitted")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	err := Wrap(errors.New("unexpected EOF"), ErrDecodingJSON, "decode failed")
+
+	data, e := err.JSON()
+	if nil != e {
+		t.Fatalf("Expected nil, received %s", e)
+	}
+	expect, _ := err.MarshalJSON()
+	if string(expect) != string(data) {
+		t.Errorf("Expected %s, received %s", expect, data)
+	}
+
+	// %+#v produces the same JSON
+	if str := fmt.Sprintf("%+#v", err); string(expect) != str {
+		t.Errorf("Expected %s, received %s", expect, str)
+	}
+	var buf bytes.Buffer
+	if e := err.Fprint(&buf, "%+#v"); nil != e || string(expect) != buf.String() {
+		t.Errorf("Expected %s, received %s (%v)", expect, buf.String(), e)
+	}
+
+	// %#v remains the condensed trace
+	if str := fmt.Sprintf("%#v", err); !strings.HasPrefix(str, "#1 - caller:") {
+		t.Errorf("Expected a condensed trace, received '%s'", str)
+	}
+}
+
+func TestTraceJSON(t *testing.T) {
+	defer SetTrimPrefix("")
+	SetTrimPrefix("/srv/app")
+
+	trace := Trace{
+		Call{file: "/srv/app/internal/store.go", line: 42, ok: true, function: "example.com/app/internal.Load"},
+		Call{file: "/srv/app/main.go", line: 7, ok: true, function: "main.main"},
+	}
+	data, e := json.Marshal(trace)
+	if nil != e {
+		t.Fatalf("Expected nil, received %s", e)
+	}
+	expect := `[{"file":"internal/store.go","line":42,"function":"example.com/app/internal.Load"},` +
+		`{"file":"main.go","line":7,"function":"main.main"}]`
+	if expect != string(data) {
+		t.Errorf("Expected '%s', received '%s'", expect, data)
+	}
+
+	expect = "internal/store.go:42 example.com/app/internal.Load\nmain.go:7 main.main"
+	if expect != trace.String() {
+		t.Errorf("Expected '%s', received '%s'", expect, trace.String())
+	}
+}
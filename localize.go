@@ -0,0 +1,37 @@
+package errors
+
+import (
+	"fmt"
+	"sync"
+)
+
+// localizer translates external error text, if set.
+var localizer func(code Code, lang string) (string, bool)
+var localizerMux = &sync.RWMutex{}
+
+// SetLocalizer sets the function used by LocalizedString to translate the
+// external error text for a code into lang. fn should return false if it
+// has no translation. Passing nil removes the localizer.
+func SetLocalizer(fn func(code Code, lang string) (string, bool)) {
+	localizerMux.Lock()
+	localizer = fn
+	localizerMux.Unlock()
+}
+
+// LocalizedString returns the external error text for the leading code
+// translated into lang, in the same format as String. The localizer only
+// receives the code and lang, so translations are looked up per code
+// rather than per message. If no translation is available, String is
+// returned.
+func (err *Err) LocalizedString(lang string) string {
+	localizerMux.RLock()
+	fn := localizer
+	localizerMux.RUnlock()
+	if nil != fn && err.Len() > 0 {
+		code := err.Code()
+		if msg, ok := fn(code, lang); ok {
+			return fmt.Sprintf("%s (code:%d)", msg, code)
+		}
+	}
+	return err.String()
+}
@@ -0,0 +1,38 @@
+package errors
+
+import (
+	"testing"
+)
+
+func TestLocalizedString(t *testing.T) {
+	defer SetLocalizer(nil)
+	SetLocalizer(func(code Code, lang string) (string, bool) {
+		translations := map[string]map[Code]string{
+			"es": {ErrDecodingJSON: "no se pudieron decodificar los datos JSON"},
+			"fr": {ErrDecodingJSON: "les données JSON n'ont pas pu être décodées"},
+		}
+		msg, ok := translations[lang][code]
+		return msg, ok
+	})
+
+	err := New(ErrDecodingJSON, "unexpected EOF")
+	tests := map[string]string{
+		"es": "no se pudieron decodificar los datos JSON (code:101)",
+		"fr": "les données JSON n'ont pas pu être décodées (code:101)",
+		"de": "JSON data could not be decoded (code:101)",
+	}
+	for lang, expect := range tests {
+		if str := err.LocalizedString(lang); expect != str {
+			t.Errorf("Expected '%s' for '%s', received '%s'", expect, lang, str)
+		}
+	}
+
+	// Untranslated codes and internal text
+	err = Wrap(err, ErrFatal, "load failed")
+	if "a fatal error occurred (code:2)" != err.LocalizedString("es") {
+		t.Errorf("Expected 'a fatal error occurred (code:2)', received '%s'", err.LocalizedString("es"))
+	}
+	if "a fatal error occurred" != err.Detail() {
+		t.Errorf("Expected 'a fatal error occurred', received '%s'", err.Detail())
+	}
+}
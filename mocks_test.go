@@ -18,11 +18,11 @@ const (
 )
 
 func init() {
-	errs.Codes[ConfigurationNotValid] = errs.ErrCode{
+	errs.MustRegisterCode(ConfigurationNotValid, errs.ErrCode{
 		Ext:  "Configuration not valid",
 		Int:  "the configuration is invalid",
 		HTTP: 500,
-	}
+	})
 }
 
 func loadConfig() error {
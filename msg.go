@@ -1,5 +1,9 @@
 package errors
 
+import (
+	"errors"
+)
+
 // ErrMsg defines the interface to error message data.
 type ErrMsg interface {
 	Caller() Caller
@@ -11,13 +15,45 @@ type ErrMsg interface {
 	Trace() Trace
 }
 
-// Msg defines a single error message.
+// Msg defines a single error message. Msg is a value type: SetCode and
+// WithFields return a modified copy rather than changing the message they
+// are called on, so the result must be stored, or pushed onto a stack, for
+// the change to take effect. Frames returned by Last, Frames and the other
+// accessors are copies, and changing them doesn't affect the stack.
 type Msg struct {
 	err    error
 	caller Caller
 	code   Code
 	msg    string
 	trace  Trace
+	stack  []uintptr
+	fields map[string]interface{}
+	// Number of consecutive frames collapsed into this one by Dedup.
+	repeat int
+	// HTTP status overriding the status of the code, if set.
+	httpStatus int
+	// Sibling errors summarized by this message, set by WrapAll.
+	children []*Err
+	// Severity overriding the severity of the code, if set.
+	severity Severity
+
+	retryable *bool
+	temporary *bool
+	timeout   *bool
+}
+
+// NewMsg returns a new error message that can be added to a stack with
+// Push. The caller is the location NewMsg was called from and the call
+// stack is captured if tracing is enabled. If data is provided msg is used
+// as a format string, otherwise msg is used as-is.
+func NewMsg(code Code, msg string, data ...interface{}) Msg {
+	str, err := formatMsg(msg, data)
+	return traceMsg(Msg{
+		err:    err,
+		caller: getCaller(),
+		code:   code,
+		msg:    str,
+	})
 }
 
 // Caller implements ErrMsg.
@@ -35,6 +71,34 @@ func (msg Msg) Error() string {
 	return msg.String()
 }
 
+// Fields returns the structured metadata attached to the message.
+func (msg Msg) Fields() map[string]interface{} {
+	return msg.fields
+}
+
+// Is implements the interface used by errors.Is. If target is an *Err or
+// a Msg, Is reports whether it carries the same code as this message.
+// Otherwise Is reports whether the underlying error matches target. A
+// message created by WrapAll also matches anything its children match.
+func (msg Msg) Is(target error) bool {
+	switch t := target.(type) {
+	case *Err:
+		if nil != t && t.Len() > 0 && t.Code() == msg.code {
+			return true
+		}
+	case Msg:
+		if t.code == msg.code {
+			return true
+		}
+	}
+	for _, child := range msg.children {
+		if errors.Is(child, target) {
+			return true
+		}
+	}
+	return nil != msg.err && errors.Is(msg.err, target)
+}
+
 // Msg implements ErrMsg.
 func (msg Msg) Msg() string {
 	return msg.msg
@@ -54,7 +118,30 @@ func (msg Msg) String() string {
 	return msg.err.Error()
 }
 
-// Trace implements ErrMsg.
+// Trace implements ErrMsg. If the call stack was captured lazily it is
+// resolved on each call.
 func (msg Msg) Trace() Trace {
+	if nil == msg.trace && len(msg.stack) > 0 {
+		return resolveStack(msg.stack)
+	}
 	return msg.trace
 }
+
+// Unwrap returns the underlying error, if any.
+func (msg Msg) Unwrap() error {
+	return msg.err
+}
+
+// WithFields returns a copy of the message with fields added to its
+// metadata. Existing fields with the same key are replaced.
+func (msg Msg) WithFields(fields map[string]interface{}) ErrMsg {
+	merged := make(map[string]interface{}, len(msg.fields)+len(fields))
+	for k, v := range msg.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	msg.fields = merged
+	return msg
+}
@@ -0,0 +1,30 @@
+package errors
+
+import (
+	"fmt"
+)
+
+// Must panics if err is not nil, for startup code where an error can't be
+// handled. The panic value is an error whose text is the detailed stack
+// trace of err, as formatted with %+v, so the full stack is preserved in
+// the panic message. The original error is available with errors.Unwrap.
+func Must(err error) {
+	if nil != err {
+		panic(mustErr{err})
+	}
+}
+
+// mustErr is the panic value used by Must.
+type mustErr struct {
+	err error
+}
+
+// Error implements error, returning the detailed stack trace of the error.
+func (e mustErr) Error() string {
+	return fmt.Sprintf("%+v", e.err)
+}
+
+// Unwrap returns the error passed to Must.
+func (e mustErr) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,12 @@
+//go:build go1.21
+// +build go1.21
+
+package errors
+
+// MustValue returns v, or panics like Must if err is not nil:
+//
+//	cfg := errors.MustValue(loadConfig(path))
+func MustValue[T any](v T, err error) T {
+	Must(err)
+	return v
+}
@@ -0,0 +1,24 @@
+//go:build go1.21
+// +build go1.21
+
+package errors
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMustValue(t *testing.T) {
+	if v := MustValue(42, nil); 42 != v {
+		t.Errorf("Expected 42, received %d", v)
+	}
+
+	v := recoverPanic(func() { MustValue("", New(ErrFatal, "parse failed")) })
+	e, ok := v.(error)
+	if !ok {
+		t.Fatalf("Expected an error, received %T", v)
+	}
+	if !strings.Contains(e.Error(), "error:   parse failed") || !strings.Contains(e.Error(), "must_generic_test.go") {
+		t.Errorf("Expected the stack trace, received '%s'", e.Error())
+	}
+}
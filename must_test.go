@@ -0,0 +1,38 @@
+package errors
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// recoverPanic returns the value of a panic in fn, if any.
+func recoverPanic(fn func()) (v interface{}) {
+	defer func() {
+		v = recover()
+	}()
+	fn()
+	return nil
+}
+
+func TestMust(t *testing.T) {
+	if v := recoverPanic(func() { Must(nil) }); nil != v {
+		t.Errorf("Expected no panic, received %v", v)
+	}
+
+	err := Wrap(New(ErrFatal, "read failed"), ErrUnknown, "load failed")
+	v := recoverPanic(func() { Must(err) })
+	e, ok := v.(error)
+	if !ok {
+		t.Fatalf("Expected an error, received %T", v)
+	}
+	if e.Error() != err.ErrorStack() {
+		t.Errorf("Expected the stack trace, received '%s'", e.Error())
+	}
+	if !strings.Contains(e.Error(), "must_test.go") || !strings.Contains(e.Error(), "read failed") {
+		t.Errorf("Expected the trace to include the root cause, received '%s'", e.Error())
+	}
+	if err != errors.Unwrap(e) {
+		t.Errorf("Expected the original error, received %v", errors.Unwrap(e))
+	}
+}
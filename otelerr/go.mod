@@ -0,0 +1,12 @@
+module github.com/lkcloud/errors/otelerr
+
+go 1.13
+
+require (
+	github.com/lkcloud/errors v0.0.0-00010101000000-000000000000
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+)
+
+replace github.com/lkcloud/errors => ../
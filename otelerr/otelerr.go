@@ -0,0 +1,34 @@
+// Package otelerr records github.com/lkcloud/errors error stacks on
+// OpenTelemetry spans.
+package otelerr
+
+import (
+	"github.com/lkcloud/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RecordOn records err on span as an exception event and adds the leading
+// code, external error text and HTTP status as the error.code,
+// error.message and error.http_status span attributes. The exception event
+// is built from the external text returned by errors.StatusFromError
+// rather than from err.Error(), since spans are often exported to systems
+// outside the service. Errors reported with a 5xx status set the span
+// status to Error, other errors leave the status unchanged. A nil err is
+// ignored.
+func RecordOn(span trace.Span, err error) {
+	if nil == err || nil == span {
+		return
+	}
+	status, code, message := errors.StatusFromError(err)
+	span.RecordError(errors.NewBare(code, message))
+	span.SetAttributes(
+		attribute.Int("error.code", int(code)),
+		attribute.String("error.message", message),
+		attribute.Int("error.http_status", status),
+	)
+	if status >= 500 {
+		span.SetStatus(codes.Error, message)
+	}
+}
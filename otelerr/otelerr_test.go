@@ -0,0 +1,95 @@
+package otelerr
+
+import (
+	"context"
+	stderrors "errors"
+	"strings"
+	"testing"
+
+	"github.com/lkcloud/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const errTestCode errors.Code = 9000
+
+func init() {
+	errors.RegisterCode(errTestCode, errors.ErrCode{Ext: "record not found", Int: "no matching row", HTTP: 404})
+}
+
+// record calls RecordOn on a new span and returns the exported span.
+func record(t *testing.T, err error) sdktrace.ReadOnlySpan {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	_, span := provider.Tracer("otelerr").Start(context.Background(), "test")
+	RecordOn(span, err)
+	span.End()
+
+	ended := recorder.Ended()
+	if 1 != len(ended) {
+		t.Fatalf("Expected 1 span, received %d", len(ended))
+	}
+	return ended[0]
+}
+
+// attributes returns kv as a map.
+func attributes(kv []attribute.KeyValue) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, attr := range kv {
+		attrs[attr.Key] = attr.Value
+	}
+	return attrs
+}
+
+func TestRecordOn(t *testing.T) {
+	err := errors.Wrap(stderrors.New("select * from users where pw=hunter2"), errTestCode, "user lookup failed")
+	span := record(t, err)
+
+	expect := map[attribute.Key]attribute.Value{
+		"error.code":        attribute.IntValue(int(errTestCode)),
+		"error.message":     attribute.StringValue("record not found"),
+		"error.http_status": attribute.IntValue(404),
+	}
+	attrs := attributes(span.Attributes())
+	for key, value := range expect {
+		if value != attrs[key] {
+			t.Errorf("Expected %s for %s, received %s", value.Emit(), key, attrs[key].Emit())
+		}
+	}
+	if codes.Unset != span.Status().Code {
+		t.Errorf("Expected the status of a client error to be unset, received %s", span.Status().Code)
+	}
+
+	// The exception event only carries external text
+	events := span.Events()
+	if 1 != len(events) || "exception" != events[0].Name {
+		t.Fatalf("Expected an exception event, received %v", events)
+	}
+	attrs = attributes(events[0].Attributes)
+	if "record not found" != attrs["exception.message"].AsString() {
+		t.Errorf("Expected 'record not found', received '%s'", attrs["exception.message"].Emit())
+	}
+	for key, value := range attrs {
+		for _, internal := range []string{"hunter2", "user lookup failed", "no matching row"} {
+			if strings.Contains(value.Emit(), internal) {
+				t.Errorf("Expected no internal error text in %s, received '%s'", key, value.Emit())
+			}
+		}
+	}
+}
+
+func TestRecordOnServerError(t *testing.T) {
+	span := record(t, errors.New(errors.ErrFatal, "disk full"))
+	if codes.Error != span.Status().Code || "a fatal error occurred" != span.Status().Description {
+		t.Errorf("Expected an error status, received %s '%s'", span.Status().Code, span.Status().Description)
+	}
+}
+
+func TestRecordOnNil(t *testing.T) {
+	span := record(t, nil)
+	if 0 != len(span.Events()) || 0 != len(span.Attributes()) {
+		t.Errorf("Expected nil to be ignored")
+	}
+}
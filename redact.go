@@ -0,0 +1,30 @@
+package errors
+
+import (
+	"sync"
+)
+
+// redactor masks sensitive data in external error text, if set.
+var redactor func(string) string
+var redactorMux = &sync.RWMutex{}
+
+// SetRedactor sets a function applied to external error text, returned by
+// Error and the %s and %v formats, so that sensitive data such as email
+// addresses or tokens can be masked before reaching users. Detailed stack
+// traces aren't redacted. Passing nil removes the redactor.
+func SetRedactor(fn func(string) string) {
+	redactorMux.Lock()
+	redactor = fn
+	redactorMux.Unlock()
+}
+
+// redact applies the redactor, if any, to str.
+func redact(str string) string {
+	redactorMux.RLock()
+	fn := redactor
+	redactorMux.RUnlock()
+	if nil != fn {
+		str = fn(str)
+	}
+	return str
+}
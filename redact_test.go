@@ -0,0 +1,73 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSetRedactor(t *testing.T) {
+	email := regexp.MustCompile(`[^\s@]+@[^\s@]+\.[a-z]+`)
+	defer SetRedactor(nil)
+	SetRedactor(func(str string) string {
+		return email.ReplaceAllString(str, "[redacted]")
+	})
+
+	err := Wrap(errors.New("smtp: rejected"), errTestCode, "delivery failed for %s", "user@example.com")
+	if "delivery failed for [redacted]" != err.Error() {
+		t.Errorf("Expected 'delivery failed for [redacted]', received '%s'", err.Error())
+	}
+	if str := fmt.Sprintf("%s", err); "delivery failed for [redacted]" != str {
+		t.Errorf("Expected 'delivery failed for [redacted]', received '%s'", str)
+	}
+	if str := fmt.Sprintf("%v", err); "delivery failed for [redacted] (code:9000)" != str {
+		t.Errorf("Expected 'delivery failed for [redacted] (code:9000)', received '%s'", str)
+	}
+	if "delivery failed for [redacted] (code:9000)" != err.String() {
+		t.Errorf("Expected 'delivery failed for [redacted] (code:9000)', received '%s'", err.String())
+	}
+
+	// Detailed traces aren't redacted
+	if str := fmt.Sprintf("%+v", err); !strings.Contains(str, "user@example.com") {
+		t.Errorf("Expected the detailed trace to be unredacted, received '%s'", str)
+	}
+}
+
+func TestSetRedactorJoin(t *testing.T) {
+	setTestCode(t, errTestCode, ErrCode{Ext: "delivery failed"})
+	defer SetRedactor(nil)
+	SetRedactor(func(str string) string {
+		return strings.Replace(str, "user@example.com", "[redacted]", -1)
+	})
+
+	err := Join(New(ErrUnknown, "delivery failed for %s", "user@example.com"), New(errTestCode, "retry failed")).(*Err)
+	if "2 errors occurred: delivery failed for [redacted]" != err.Error() {
+		t.Errorf("Expected '2 errors occurred: delivery failed for [redacted]', received '%s'", err.Error())
+	}
+	if "2 errors occurred: delivery failed for user@example.com" != err.Detail() {
+		t.Errorf("Expected '2 errors occurred: delivery failed for user@example.com', received '%s'", err.Detail())
+	}
+}
+
+func TestSetRedactorDetail(t *testing.T) {
+	setTestCode(t, errTestCode, ErrCode{Ext: "delivery failed"})
+
+	err := New(errTestCode, "delivery failed for %s", "user@example.com")
+	expect := err.Detail()
+	if "delivery failed for user@example.com" != expect {
+		t.Errorf("Expected 'delivery failed for user@example.com', received '%s'", expect)
+	}
+
+	defer SetRedactor(nil)
+	SetRedactor(func(str string) string {
+		return strings.Replace(str, "user@example.com", "[redacted]", -1)
+	})
+	if expect != err.Detail() {
+		t.Errorf("Expected '%s', received '%s'", expect, err.Detail())
+	}
+	if "delivery failed for [redacted]" != err.Error() {
+		t.Errorf("Expected 'delivery failed for [redacted]', received '%s'", err.Error())
+	}
+}
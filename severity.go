@@ -0,0 +1,76 @@
+package errors
+
+// Severity defines the severity of an error, used to choose a log level.
+// The zero value means the severity isn't set.
+type Severity int
+
+// Severities, from least to most severe.
+const (
+	SeverityDebug Severity = iota + 1
+	SeverityInfo
+	SeverityWarn
+	SeverityError
+	SeverityFatal
+)
+
+// String implements Stringer, returning the lower case name of the
+// severity, such as "warn".
+func (severity Severity) String() string {
+	switch severity {
+	case SeverityDebug:
+		return "debug"
+	case SeverityInfo:
+		return "info"
+	case SeverityWarn:
+		return "warn"
+	case SeverityError:
+		return "error"
+	case SeverityFatal:
+		return "fatal"
+	}
+	return ""
+}
+
+// SeverityCoder defines an optional interface for Coder implementations
+// that map an error code to a severity.
+type SeverityCoder interface {
+	// Severity that should be used for the associated error code.
+	Level() Severity
+}
+
+// Level implements SeverityCoder. Level returns the associated severity,
+// if any. Otherwise, returns SeverityError.
+func (code ErrCode) Level() Severity {
+	if 0 == code.Severity {
+		return SeverityError
+	}
+	return code.Severity
+}
+
+// Level returns the severity of the error. A severity set on the most
+// recent frame with WithSeverity takes precedence, followed by the
+// severity of the leading code. If the code metadata doesn't implement
+// SeverityCoder, SeverityError is returned.
+func (err *Err) Level() Severity {
+	if err.Len() > 0 {
+		last := err.Last()
+		if msg, ok := last.(Msg); ok && 0 != msg.severity {
+			return msg.severity
+		}
+		if code, ok := LookupCode(last.Code()); ok {
+			if coder, ok := code.(SeverityCoder); ok {
+				return coder.Level()
+			}
+		}
+	}
+	return SeverityError
+}
+
+// WithSeverity sets the severity of the most recent error in the stack,
+// overriding the severity of its code.
+func (err *Err) WithSeverity(severity Severity) *Err {
+	return err.updateLast(func(msg Msg) Msg {
+		msg.severity = severity
+		return msg
+	})
+}
@@ -0,0 +1,65 @@
+package errors
+
+import (
+	"testing"
+)
+
+func TestLevel(t *testing.T) {
+	tests := []struct {
+		code   ErrCode
+		expect Severity
+	}{
+		{ErrCode{Severity: SeverityDebug}, SeverityDebug},
+		{ErrCode{Severity: SeverityInfo}, SeverityInfo},
+		{ErrCode{Severity: SeverityWarn}, SeverityWarn},
+		{ErrCode{Severity: SeverityFatal}, SeverityFatal},
+		// Default severity
+		{ErrCode{HTTP: 404}, SeverityError},
+	}
+	for _, test := range tests {
+		setTestCode(t, errTestCode, test.code)
+		err := New(errTestCode, "severity")
+		if level := err.Level(); test.expect != level {
+			t.Errorf("Expected %s for %+v, received %s", test.expect, test.code, level)
+		}
+	}
+
+	if SeverityFatal != New(ErrFatal, "fatal").Level() {
+		t.Errorf("Expected ErrFatal to default to %s", SeverityFatal)
+	}
+	if SeverityError != New(Code(9999), "unregistered").Level() {
+		t.Errorf("Expected unregistered codes to default to %s", SeverityError)
+	}
+}
+
+func TestWithSeverity(t *testing.T) {
+	err := New(ErrFatal, "cache miss").WithSeverity(SeverityDebug)
+	if SeverityDebug != err.Level() {
+		t.Errorf("Expected %s, received %s", SeverityDebug, err.Level())
+	}
+
+	// The override applies to its own frame only
+	wrapped := Wrap(err, ErrUnknown, "lookup failed")
+	if SeverityError != wrapped.Level() {
+		t.Errorf("Expected %s, received %s", SeverityError, wrapped.Level())
+	}
+	if SeverityWarn != wrapped.WithSeverity(SeverityWarn).Level() {
+		t.Errorf("Expected %s, received %s", SeverityWarn, wrapped.Level())
+	}
+}
+
+func TestSeverityString(t *testing.T) {
+	names := map[Severity]string{
+		SeverityDebug: "debug",
+		SeverityInfo:  "info",
+		SeverityWarn:  "warn",
+		SeverityError: "error",
+		SeverityFatal: "fatal",
+		0:             "",
+	}
+	for severity, name := range names {
+		if name != severity.String() {
+			t.Errorf("Expected '%s', received '%s'", name, severity.String())
+		}
+	}
+}
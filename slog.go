@@ -0,0 +1,22 @@
+//go:build go1.21
+// +build go1.21
+
+package errors
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// LogValue implements slog.LogValuer. LogValue returns a group containing
+// the leading code, external and internal error text, HTTP status and the
+// inline stack trace.
+func (err *Err) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int("code", int(err.Code())),
+		slog.String("message", err.externalText()),
+		slog.String("detail", err.Detail()),
+		slog.Int("http_status", err.HTTPStatus()),
+		slog.String("trace", fmt.Sprintf("%-v", err)),
+	)
+}
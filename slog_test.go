@@ -0,0 +1,59 @@
+//go:build go1.21
+// +build go1.21
+
+package errors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+)
+
+// captureHandler is a slog.Handler that records the attributes of each
+// record.
+type captureHandler struct {
+	attrs map[string]slog.Value
+}
+
+func (h *captureHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *captureHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *captureHandler) WithGroup(string) slog.Handler            { return h }
+func (h *captureHandler) Handle(_ context.Context, r slog.Record) error {
+	r.Attrs(func(attr slog.Attr) bool {
+		attr.Value = attr.Value.Resolve()
+		if slog.KindGroup == attr.Value.Kind() {
+			for _, a := range attr.Value.Group() {
+				h.attrs[attr.Key+"."+a.Key] = a.Value
+			}
+		} else {
+			h.attrs[attr.Key] = attr.Value
+		}
+		return true
+	})
+	return nil
+}
+
+func TestLogValue(t *testing.T) {
+	setTestCode(t, errTestCode, ErrCode{Ext: "record not found", Int: "no matching row", HTTP: 404})
+
+	err := Wrap(errors.New("sql: no rows"), errTestCode, "user lookup failed")
+	handler := &captureHandler{attrs: map[string]slog.Value{}}
+	slog.New(handler).Error("failed", "err", err)
+
+	expect := map[string]string{
+		"err.code":        "9000",
+		"err.message":     "record not found",
+		"err.detail":      "no matching row",
+		"err.http_status": "404",
+		"err.trace":       fmt.Sprintf("%-v", err),
+	}
+	for key, value := range expect {
+		if attr, ok := handler.attrs[key]; !ok {
+			t.Errorf("Expected attribute '%s'", key)
+		} else if value != attr.String() {
+			t.Errorf("Expected '%s' for '%s', received '%s'", value, key, attr.String())
+		}
+	}
+}
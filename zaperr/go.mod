@@ -0,0 +1,10 @@
+module github.com/lkcloud/errors/zaperr
+
+go 1.13
+
+require (
+	github.com/lkcloud/errors v0.0.0-00010101000000-000000000000
+	go.uber.org/zap v1.21.0
+)
+
+replace github.com/lkcloud/errors => ../
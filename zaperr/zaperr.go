@@ -0,0 +1,38 @@
+/*
+Package zaperr encodes github.com/lkcloud/errors error stacks as zap
+objects, so the code, status and trace of an error are logged as separate
+fields:
+
+	logger.Error("request failed", zap.Object("err", zaperr.Object(err)))
+*/
+package zaperr
+
+import (
+	"fmt"
+
+	"github.com/lkcloud/errors"
+	"go.uber.org/zap/zapcore"
+)
+
+// errObject implements zapcore.ObjectMarshaler for an error stack.
+type errObject struct {
+	err *errors.Err
+}
+
+// Object returns a zapcore.ObjectMarshaler for err, to be logged with
+// zap.Object. The leading code, external and internal error text, HTTP
+// status and inline stack trace are encoded.
+func Object(err *errors.Err) zapcore.ObjectMarshaler {
+	return errObject{err: err}
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (obj errObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	status, code, message := errors.StatusFromError(obj.err)
+	enc.AddInt("code", int(code))
+	enc.AddString("message", message)
+	enc.AddString("detail", obj.err.Detail())
+	enc.AddInt("http_status", status)
+	enc.AddString("trace", fmt.Sprintf("%-v", obj.err))
+	return nil
+}
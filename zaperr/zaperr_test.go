@@ -0,0 +1,46 @@
+package zaperr
+
+import (
+	stderrors "errors"
+	"fmt"
+	"testing"
+
+	"github.com/lkcloud/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+const errTestCode errors.Code = 9000
+
+func init() {
+	errors.RegisterCode(errTestCode, errors.ErrCode{Ext: "record not found", Int: "no matching row", HTTP: 404})
+}
+
+func TestObject(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	err := errors.Wrap(stderrors.New("sql: no rows"), errTestCode, "user lookup failed")
+	zap.New(core).Error("failed", zap.Object("err", Object(err)))
+
+	entries := logs.All()
+	if 1 != len(entries) {
+		t.Fatalf("Expected 1 entry, received %d", len(entries))
+	}
+	fields, ok := entries[0].ContextMap()["err"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected an object, received %T", entries[0].ContextMap()["err"])
+	}
+
+	expect := map[string]string{
+		"code":        "9000",
+		"message":     "record not found",
+		"detail":      "no matching row",
+		"http_status": "404",
+		"trace":       fmt.Sprintf("%-v", err),
+	}
+	for key, value := range expect {
+		if value != fmt.Sprint(fields[key]) {
+			t.Errorf("Expected '%v' for '%s', received '%v'", value, key, fields[key])
+		}
+	}
+}